@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ricoberger/sidecar-injector/pkg/sidecar"
+
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// explain loads the given configuration file and prints why resources are or
+// are not injected into the Pod from the given manifest file. If the file is
+// "-", the manifest is read from stdin. The explanation is printed as text or
+// as JSON, depending on the given output format. Native sidecar containers are
+// only injected, when nativeSidecars is true. It returns the exit code for the
+// webhook.
+func explain(configFile, file, output string, nativeSidecars bool) int {
+	cfg, err := sidecar.LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load configuration file %s: %s\n", configFile, err.Error())
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open manifest file %s: %s\n", file, err.Error())
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	pod := &corev1.Pod{}
+	if err := utilyaml.NewYAMLOrJSONDecoder(r, 4096).Decode(pod); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not decode Pod: %s\n", err.Error())
+		return 1
+	}
+	if pod.Kind != "Pod" {
+		fmt.Fprintf(os.Stderr, "Manifest must contain a Pod, got %q\n", pod.Kind)
+		return 1
+	}
+
+	injector := &sidecar.Injector{
+		Config:         cfg,
+		Decoder:        admission.NewDecoder(scheme),
+		NativeSidecars: nativeSidecars,
+	}
+
+	explanation, err := injector.Explain(context.Background(), pod)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not explain injection: %s\n", err.Error())
+		return 1
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(explanation)
+	} else {
+		err = explanation.Write(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write explanation: %s\n", err.Error())
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ricoberger/sidecar-injector/pkg/sidecar"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// inject loads the given configuration file and injects the resources into
+// all Pods and workloads from the given manifest file. The mutated manifests
+// are written to stdout. If the file is "-", the manifests are read from
+// stdin. It returns the exit code for the webhook.
+func inject(configFile, file string, nativeSidecars bool) int {
+	cfg, err := sidecar.LoadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load configuration file %s: %s\n", configFile, err.Error())
+		return 1
+	}
+
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open manifest file %s: %s\n", file, err.Error())
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	injector := &sidecar.Injector{
+		Config:         cfg,
+		Decoder:        admission.NewDecoder(scheme),
+		NativeSidecars: nativeSidecars,
+	}
+
+	if err := injector.InjectManifests(context.Background(), r, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not inject manifests: %s\n", err.Error())
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ricoberger/sidecar-injector/pkg/sidecar"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// validate loads the given configuration file and prints all problems found
+// in the configuration. It returns the exit code for the webhook, which is
+// non-zero when the configuration is invalid.
+func validate(file string) int {
+	_, err := sidecar.LoadConfig(file)
+	if err == nil {
+		fmt.Fprintf(os.Stdout, "Configuration file %s is valid.\n", file)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Configuration file %s is invalid:\n", file)
+
+	var agg utilerrors.Aggregate
+	if errors.As(err, &agg) {
+		for _, err := range agg.Errors() {
+			fmt.Fprintf(os.Stderr, "  - %s\n", err.Error())
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "  - %s\n", err.Error())
+	}
+
+	return 1
+}
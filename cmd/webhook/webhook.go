@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
 	"github.com/ricoberger/sidecar-injector/pkg/sidecar"
 	"github.com/ricoberger/sidecar-injector/pkg/version"
 
 	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -22,10 +28,19 @@ import (
 )
 
 var (
-	certDir     string
-	configFile  string
-	showVersion bool
-	log         = logf.Log.WithName("webhook")
+	certDir         string
+	configFile      string
+	customResources bool
+	detectOutdated  bool
+	restartOutdated bool
+	injectFile      string
+	injectNative    bool
+	explainOutput   string
+	debugEndpoint   bool
+	leaderElection  bool
+	showVersion     bool
+	log             = logf.Log.WithName("webhook")
+	scheme          = runtime.NewScheme()
 )
 
 // init is used to define all flags for external-authz.
@@ -42,7 +57,18 @@ func init() {
 
 	flag.StringVar(&certDir, "certs", defaultCertDir, "Folder containing the x509 certificate and key file.")
 	flag.StringVar(&configFile, "config", defaultConfigFile, "Name of the configuration file.")
+	flag.BoolVar(&customResources, "custom-resources", os.Getenv("WEBHOOK_CUSTOM_RESOURCES") == "true", "Use the SidecarTemplate and SidecarInjector custom resources in addition to the configuration file.")
+	flag.BoolVar(&detectOutdated, "detect-outdated-sidecars", os.Getenv("WEBHOOK_DETECT_OUTDATED_SIDECARS") == "true", "Detect Pods which were injected with an outdated configuration.")
+	flag.BoolVar(&restartOutdated, "restart-outdated-workloads", os.Getenv("WEBHOOK_RESTART_OUTDATED_WORKLOADS") == "true", "Restart Deployments and StatefulSets with outdated Pods. Requires --detect-outdated-sidecars.")
+	flag.StringVarP(&injectFile, "filename", "f", "-", "Manifest file for the inject and explain commands. If it is \"-\", the manifests are read from stdin.")
+	flag.BoolVar(&injectNative, "native-sidecars", true, "Inject native sidecar containers in the inject and explain commands.")
+	flag.StringVarP(&explainOutput, "output", "o", "text", "Output format of the explain command. One of \"text\" or \"json\".")
+	flag.BoolVar(&debugEndpoint, "debug-endpoint", os.Getenv("WEBHOOK_DEBUG_ENDPOINT") == "true", "Enable the authenticated \"/debug/explain\" endpoint on the webhook server.")
+	flag.BoolVar(&leaderElection, "leader-election", os.Getenv("WEBHOOK_LEADER_ELECTION") != "false", "Enable leader election, so that only one replica runs the controllers for the status of the custom resources and for outdated sidecars.")
 	flag.BoolVar(&showVersion, "version", false, "Print version information.")
+
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 }
 
 func main() {
@@ -70,18 +96,43 @@ func main() {
 		return
 	}
 
+	// When the "validate" command is used, we only validate the configuration
+	// file and print all problems found in the configuration. This can be used
+	// to check changes to the configuration before they are deployed.
+	if flag.Arg(0) == "validate" {
+		os.Exit(validate(configFile))
+	}
+
+	// When the "inject" command is used, we inject the resources into the
+	// Pods and workloads of the given manifests and print the mutated
+	// manifests, like the webhook would mutate the Pods in the cluster.
+	if flag.Arg(0) == "inject" {
+		os.Exit(inject(configFile, injectFile, injectNative))
+	}
+
+	// When the "explain" command is used, we print why resources are or are
+	// not injected into the Pod of the given manifest.
+	if flag.Arg(0) == "explain" {
+		os.Exit(explain(configFile, injectFile, explainOutput, injectNative))
+	}
+
 	log.Info("Version information", version.Info()...)
 	log.Info("Build context", version.BuildContext()...)
 
-	c, err := sidecar.LoadConfig(configFile)
+	reloader, err := sidecar.NewConfigReloader(configFile)
 	if err != nil {
 		log.Error(err, "Could not load configuration file.")
 		os.Exit(1)
 	}
 
-	// Setup a Manager
+	// Setup a Manager. The webhook server and the configuration reloader run
+	// in all replicas, while the controllers are only run by the leader, so
+	// that the replicas do not update the same objects concurrently.
 	log.Info("Settings up manager.")
 	mgr, err := manager.New(config.GetConfigOrDie(), manager.Options{
+		Scheme:           scheme,
+		LeaderElection:   leaderElection,
+		LeaderElectionID: "sidecar-injector.ricoberger.de",
 		WebhookServer: webhook.NewServer(webhook.Options{
 			Port:    8443,
 			CertDir: certDir,
@@ -101,23 +152,93 @@ func main() {
 	mgr.AddReadyzCheck("readyz", func(req *http.Request) error {
 		return nil
 	})
+	// A failed reload of the configuration is reported via the "config"
+	// readiness check. The check is excluded in the readiness probe of the
+	// Helm chart, because the webhook still serves requests with the last
+	// valid configuration.
+	mgr.AddReadyzCheck("config", reloader.Check)
 	mgr.AddHealthzCheck("healthz", func(req *http.Request) error {
 		return nil
 	})
 
+	// Watch the configuration file, so that changes to the file are applied
+	// without restarting the webhook.
+	if err := mgr.Add(reloader); err != nil {
+		log.Error(err, "Unable to add configuration reloader to manager.")
+		os.Exit(1)
+	}
+
+	// Setup Controllers
+	if customResources {
+		log.Info("Setting up status controller for custom resources.")
+		if err := (&sidecar.StatusReconciler{
+			Client:   mgr.GetClient(),
+			Reloader: reloader,
+		}).SetupWithManager(mgr); err != nil {
+			log.Error(err, "Unable to set up status controller.")
+			os.Exit(1)
+		}
+	}
+
+	// Check if the Kubernetes cluster supports native sidecar containers. If
+	// we can not determine the version of the API server, we inject all
+	// containers as regular containers.
+	nativeSidecars := false
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		log.Error(err, "Unable to create discovery client.")
+	} else {
+		nativeSidecars, err = sidecar.SupportsNativeSidecars(discoveryClient)
+		if err != nil {
+			log.Error(err, "Unable to check if native sidecar containers are supported.")
+		}
+	}
+	log.Info("Native sidecar containers.", "supported", nativeSidecars)
+
 	// Setup Webhooks
 	log.Info("Setting up webhook server.")
 	hookServer := mgr.GetWebhookServer()
 
+	injector := &sidecar.Injector{
+		Client:   mgr.GetClient(),
+		Decoder:  admission.NewDecoder(mgr.GetScheme()),
+		Reloader: reloader,
+
+		CustomResources: customResources,
+		NativeSidecars:  nativeSidecars,
+		Events:          sidecar.NewEventRecorder(mgr.GetEventRecorder("sidecar-injector"), mgr.GetAPIReader()),
+	}
+
 	log.Info("Registering webhooks to the webhook server.")
 	hookServer.Register("/mutate", &webhook.Admission{
-		Handler: &sidecar.Injector{
-			Client:  mgr.GetClient(),
-			Config:  c,
-			Decoder: admission.NewDecoder(mgr.GetScheme()),
-		},
+		Handler: injector,
 	})
 
+	// The debug endpoint explains why resources are or are not injected into
+	// a Pod. Since the explanation contains the configuration, the requests
+	// are authenticated and authorized via the Kubernetes API server.
+	if debugEndpoint {
+		log.Info("Registering debug endpoint to the webhook server.")
+		hookServer.Register("/debug/explain", sidecar.AuthenticatedHandler(mgr.GetClient(), injector.ExplainHandler()))
+	}
+
+	// Setup the controller to detect Pods with outdated sidecars. The
+	// controller checks all Pods on each change of a Pod and periodically, so
+	// that changes of the configuration file are also detected.
+	if detectOutdated {
+		log.Info("Setting up controller for outdated sidecars.", "restart", restartOutdated)
+		if err := (&sidecar.StaleReconciler{
+			Client:   mgr.GetClient(),
+			Injector: injector,
+
+			RestartWorkloads: restartOutdated,
+			Interval:         5 * time.Minute,
+		}).SetupWithManager(mgr); err != nil {
+			log.Error(err, "Unable to set up controller for outdated sidecars.")
+			os.Exit(1)
+		}
+	}
+
 	log.Info("Starting manager.")
 	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
 		log.Error(err, "Unable to run manager.")
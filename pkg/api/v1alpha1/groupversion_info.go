@@ -0,0 +1,23 @@
+// Package v1alpha1 contains the SidecarTemplate and SidecarInjector custom
+// resources, which can be used to configure the sidecar injector without
+// changing the configuration file.
+//
+// +kubebuilder:object:generate=true
+// +groupName=sidecar-injector.ricoberger.de
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is group version used to register these objects.
+	GroupVersion = schema.GroupVersion{Group: "sidecar-injector.ricoberger.de", Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme.
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,76 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionTypeValid is the type of the condition, which is used to report
+	// if a SidecarTemplate or SidecarInjector can be used by the webhook.
+	ConditionTypeValid = "Valid"
+)
+
+// SidecarInjectorSpec defines the Pods, which should be selected by the
+// injector and the SidecarTemplates which should be injected into these Pods.
+type SidecarInjectorSpec struct {
+	Selector  metav1.LabelSelector `json:"selector"`
+	Templates []string             `json:"templates"`
+
+	// NamespaceSelector can be used to select Pods based on the labels of their
+	// Namespace. If it is set, the selector and the namespace selector must
+	// both match for the injector to apply.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Priority defines the order in which the templates of multiple matching
+	// injectors are merged. The templates of injectors with a higher priority
+	// are injected first.
+	Priority int `json:"priority,omitempty"`
+
+	// Exclusive defines that no other injector should be applied to a Pod, when
+	// the injector matches the Pod.
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	// NativeSidecars defines that the containers of the referenced templates
+	// should be injected as native sidecar containers, which are init
+	// containers with the restart policy "Always".
+	NativeSidecars bool `json:"nativeSidecars,omitempty"`
+
+	// Mandatory defines that Pods can not opt out of the injector via
+	// annotations.
+	Mandatory bool `json:"mandatory,omitempty"`
+}
+
+// SidecarInjectorStatus defines the observed state of a SidecarInjector.
+type SidecarInjectorStatus struct {
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+	MatchedPods int32              `json:"matchedPods"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Matched Pods",type=integer,JSONPath=`.status.matchedPods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SidecarInjector is the Schema for the sidecarinjectors API.
+type SidecarInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarInjectorSpec   `json:"spec,omitempty"`
+	Status SidecarInjectorStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SidecarInjectorList contains a list of SidecarInjectors.
+type SidecarInjectorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SidecarInjector `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarInjector{}, &SidecarInjectorList{})
+}
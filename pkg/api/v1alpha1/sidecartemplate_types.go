@@ -0,0 +1,62 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SidecarTemplateSpec defines the init containers, containers and volumes,
+// which can be injected into a Pod. The names of the containers and volumes
+// must be unique across all SidecarTemplates and the configuration file.
+type SidecarTemplateSpec struct {
+	InitContainers []corev1.Container `json:"initContainers,omitempty"`
+	Containers     []corev1.Container `json:"containers,omitempty"`
+	Volumes        []corev1.Volume    `json:"volumes,omitempty"`
+
+	// OnConflict defines what should happen, when a Pod already contains a
+	// container or volume with the same name as a container or volume of the
+	// template. If it is not set, the default strategy from the configuration
+	// file is used.
+	// +kubebuilder:validation:Enum=Fail;Skip;Replace
+	OnConflict string `json:"onConflict,omitempty"`
+
+	// Templates enables the rendering of templates in the containers and
+	// volumes of the template. If it is not set, the fields are injected as
+	// they are.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// SidecarTemplateStatus defines the observed state of a SidecarTemplate.
+type SidecarTemplateStatus struct {
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+	MatchedPods int32              `json:"matchedPods"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Matched Pods",type=integer,JSONPath=`.status.matchedPods`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SidecarTemplate is the Schema for the sidecartemplates API.
+type SidecarTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SidecarTemplateSpec   `json:"spec,omitempty"`
+	Status SidecarTemplateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// SidecarTemplateList contains a list of SidecarTemplates.
+type SidecarTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SidecarTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SidecarTemplate{}, &SidecarTemplateList{})
+}
@@ -0,0 +1,141 @@
+package sidecar
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// knownAnnotations are the annotations of the sidecar injector, which are not
+// specific for a container.
+var knownAnnotations = []string{
+	annotationInjectKey,
+	annotationContainersKey,
+	annotationInitContainersKey,
+	annotationVolumesKey,
+	annotationStatusKey,
+	annotationInjectorsKey,
+	annotationRecordKey,
+	annotationExcludeContainersKey,
+	annotationRestartedAtKey,
+}
+
+// checkDefined returns an error, when an init container, container or volume
+// is not defined in the configuration. Since the names from the injectors are
+// validated, this can only happen for names from the annotations of the Pod.
+// Unknown names are never ignored, so that a Pod which requests a sidecar is
+// not created without it.
+func (r *resources) checkDefined(idx *configIndex) error {
+	for _, name := range r.initContainers {
+		if _, ok := idx.containers[name]; !ok {
+			return fmt.Errorf("container %q from annotation %q is not defined in the configuration", name, annotationInitContainersKey)
+		}
+	}
+	for _, name := range r.containers {
+		if _, ok := idx.containers[name]; !ok {
+			return fmt.Errorf("container %q from annotation %q is not defined in the configuration", name, annotationContainersKey)
+		}
+	}
+	for _, name := range r.volumes {
+		if _, ok := idx.volumes[name]; !ok {
+			return fmt.Errorf("volume %q from annotation %q is not defined in the configuration", name, annotationVolumesKey)
+		}
+	}
+
+	return nil
+}
+
+// checkAnnotations returns a message for each annotation of the Pod, which is
+// ignored by the sidecar injector. These are unknown annotations with the
+// prefix of the sidecar injector, resource annotations and environment
+// variable annotations for containers which are not injected, parameter
+// annotations for volumes which are not injected and containers
+// in the exclude annotation which are not defined in the configuration.
+func checkAnnotations(annotations map[string]string, cfg *Config, idx *configIndex, res *resources) []string {
+	var messages []string
+
+	for _, key := range sortedKeys(annotations) {
+		if slices.Contains(knownAnnotations, key) {
+			continue
+		}
+
+		// The annotations for the environment variables can have any name,
+		// so that they are checked before the prefix.
+		if index := slices.IndexFunc(cfg.EnvironmentVariables, func(e EnvironmentVariable) bool { return e.Annotation == key }); index >= 0 {
+			used := slices.ContainsFunc(cfg.EnvironmentVariables, func(e EnvironmentVariable) bool {
+				return e.Annotation == key && (slices.ContainsFunc(res.initContainers, e.targets) || slices.ContainsFunc(res.containers, e.targets))
+			})
+			if !used {
+				messages = append(messages, fmt.Sprintf("annotation %q is not used, because environment variable %q is not added to an injected container", key, cfg.EnvironmentVariables[index].Name))
+			}
+			continue
+		}
+
+		// The parameters of the volumes can also have any name.
+		if index := slices.IndexFunc(cfg.Volumes, func(v Volume) bool { return v.hasParameter(key) }); index >= 0 {
+			used := slices.ContainsFunc(cfg.Volumes, func(v Volume) bool {
+				return v.hasParameter(key) && slices.Contains(res.volumes, v.Name)
+			})
+			if !used {
+				messages = append(messages, fmt.Sprintf("annotation %q is not used, because volume %q is not injected", key, cfg.Volumes[index].Name))
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(key, annotationInjectKey+"/") {
+			continue
+		}
+
+		if kind, name, ok := parseResourceAnnotation(key); ok {
+			injected := res.containers
+			if kind == kindInitContainer {
+				injected = res.initContainers
+			}
+			if !slices.Contains(injected, name) {
+				messages = append(messages, fmt.Sprintf("annotation %q is ignored, because %s %q is not injected", key, strings.ReplaceAll(kind, "_", " "), name))
+			}
+			continue
+		}
+
+		messages = append(messages, fmt.Sprintf("annotation %q is unknown", key))
+	}
+
+	if excludedContainerNames, ok := annotations[annotationExcludeContainersKey]; ok && excludedContainerNames != "" {
+		for _, name := range strings.Split(excludedContainerNames, ",") {
+			if _, ok := idx.containers[name]; !ok {
+				messages = append(messages, fmt.Sprintf("container %q from annotation %q is not defined in the configuration", name, annotationExcludeContainersKey))
+			}
+		}
+	}
+
+	return messages
+}
+
+// parseResourceAnnotation returns the kind and the name of the container for
+// an annotation, which overwrites the resources of an init container or
+// container. If the annotation is not a resource annotation, false is
+// returned.
+func parseResourceAnnotation(key string) (string, string, bool) {
+	var kind, rest string
+	switch {
+	case strings.HasPrefix(key, annotationInitContainersKey+"-"):
+		kind, rest = kindInitContainer, strings.TrimPrefix(key, annotationInitContainersKey+"-")
+	case strings.HasPrefix(key, annotationContainersKey+"-"):
+		kind, rest = kindContainer, strings.TrimPrefix(key, annotationContainersKey+"-")
+	default:
+		return "", "", false
+	}
+
+	suffixes := []string{resourcesAnnotationSuffix}
+	for _, resourceAnnotation := range resourceAnnotations {
+		suffixes = append(suffixes, resourceAnnotation.suffix)
+	}
+
+	for _, suffix := range suffixes {
+		if name, ok := strings.CutSuffix(rest, "-"+suffix); ok && name != "" {
+			return kind, name, true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,139 @@
+package sidecar
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// appContainerMutation is a mutation for the existing containers of a Pod
+// together with the name of the injector, which defines the mutation, and the
+// index of the mutation in the injector.
+type appContainerMutation struct {
+	injector string
+	index    int
+	mutation AppContainerMutation
+}
+
+// deepCopy returns a copy of the mutation, so that the templates of the copy
+// can be rendered without changing the configuration.
+func (m AppContainerMutation) deepCopy() AppContainerMutation {
+	out := AppContainerMutation{
+		Containers: slices.Clone(m.Containers),
+		OnConflict: m.OnConflict,
+		Templates:  m.Templates,
+	}
+	for _, volumeMount := range m.VolumeMounts {
+		out.VolumeMounts = append(out.VolumeMounts, *volumeMount.DeepCopy())
+	}
+	for _, envVar := range m.Env {
+		out.Env = append(out.Env, *envVar.DeepCopy())
+	}
+	for _, envFrom := range m.EnvFrom {
+		out.EnvFrom = append(out.EnvFrom, *envFrom.DeepCopy())
+	}
+
+	return out
+}
+
+// matches returns true, when the mutation should be applied to the container
+// with the given name. The patterns were validated when the configuration was
+// loaded, so that invalid patterns are treated as not matching.
+func (m AppContainerMutation) matches(name string) bool {
+	if len(m.Containers) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.Containers {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mutateAppContainer applies the given mutation to the container with the
+// given index and returns if the container was changed. If the container
+// already contains a volume mount with the same mount path or an environment
+// variable with the same name, the given conflict strategy is applied. For the
+// "Skip" and "Replace" strategies a warning is returned, which describes the
+// decision. Entries which are already contained in the container are ignored.
+func mutateAppContainer(pod *corev1.Pod, patch *podPatch, index int, mutation AppContainerMutation, strategy ConflictStrategy) (bool, []string, error) {
+	container := &pod.Spec.Containers[index]
+	containerPath := fmt.Sprintf("%s/%d", pathContainers, index)
+
+	var changed bool
+	var warnings []string
+
+	for _, volumeMount := range mutation.VolumeMounts {
+		ok, warning, err := mergeItem(patch, containerPath+"/volumeMounts", &container.VolumeMounts, volumeMount, func(m corev1.VolumeMount) bool {
+			return m.MountPath == volumeMount.MountPath
+		}, strategy, fmt.Sprintf("volume mount %q", volumeMount.MountPath), "path", container.Name)
+		if err != nil {
+			return false, nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		changed = changed || ok
+	}
+
+	for _, envVar := range mutation.Env {
+		ok, warning, err := mergeItem(patch, containerPath+"/env", &container.Env, envVar, func(e corev1.EnvVar) bool {
+			return e.Name == envVar.Name
+		}, strategy, fmt.Sprintf("environment variable %q", envVar.Name), "name", container.Name)
+		if err != nil {
+			return false, nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		changed = changed || ok
+	}
+
+	// The envFrom entries do not have a name, so that they can not conflict
+	// with the entries of the container.
+	for _, envFrom := range mutation.EnvFrom {
+		if slices.ContainsFunc(container.EnvFrom, func(e corev1.EnvFromSource) bool { return equality.Semantic.DeepEqual(e, envFrom) }) {
+			continue
+		}
+		patch.append(containerPath+"/envFrom", len(container.EnvFrom), envFrom)
+		container.EnvFrom = append(container.EnvFrom, envFrom)
+		changed = true
+	}
+
+	return changed, warnings, nil
+}
+
+// mergeItem adds the given item to the list at the given path of a container
+// and returns if the list was changed. The conflict function returns true for
+// the items of the list, which conflict with the given item. The description
+// of the item and the attribute, which caused the conflict, are used in the
+// warnings and errors.
+func mergeItem[T any](patch *podPatch, listPath string, items *[]T, item T, conflict func(T) bool, strategy ConflictStrategy, description, attribute, containerName string) (bool, string, error) {
+	index := slices.IndexFunc(*items, conflict)
+	if index < 0 {
+		patch.append(listPath, len(*items), item)
+		*items = append(*items, item)
+		return true, "", nil
+	}
+
+	if equality.Semantic.DeepEqual((*items)[index], item) {
+		return false, "", nil
+	}
+
+	switch strategy {
+	case ConflictStrategySkip:
+		return false, fmt.Sprintf("%s was not added to container %q, because the container already contains an entry with the same %s", description, containerName, attribute), nil
+	case ConflictStrategyReplace:
+		patch.replace(listPath, index, item)
+		(*items)[index] = item
+		return true, fmt.Sprintf("%s of container %q was replaced", description, containerName), nil
+	default:
+		return false, "", fmt.Errorf("%s can not be added to container %q, because the container already contains an entry with the same %s", description, containerName, attribute)
+	}
+}
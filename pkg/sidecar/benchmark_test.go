@@ -0,0 +1,199 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// newBenchmarkPod returns a large Pod with the given number of containers and
+// volumes, which is used to benchmark the injection.
+func newBenchmarkPod(containers, volumes int) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "benchmark",
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "benchmark"},
+			Annotations: map[string]string{},
+		},
+	}
+
+	for i := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:  fmt.Sprintf("container-%d", i),
+			Image: "app:v1",
+			Env:   []corev1.EnvVar{{Name: "KEY", Value: "value"}},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			},
+			VolumeMounts: []corev1.VolumeMount{{Name: fmt.Sprintf("volume-%d", i%volumes), MountPath: "/data"}},
+		})
+		pod.Annotations[fmt.Sprintf("example.com/annotation-%d", i)] = "value"
+	}
+	for i := range volumes {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         fmt.Sprintf("volume-%d", i),
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	return pod
+}
+
+func newBenchmarkInjector() *Injector {
+	return &Injector{
+		Config: &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:       metav1.LabelSelector{MatchLabels: map[string]string{"app": "benchmark"}},
+					InitContainers: []string{"setup"},
+					Containers:     []string{"log-shipper", "proxy"},
+					Volumes:        []string{"logs"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "setup", Image: "setup"}},
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper", VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/logs"}}}},
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			},
+		},
+		Decoder: admission.NewDecoder(scheme.Scheme),
+		dryRun:  true,
+	}
+}
+
+// BenchmarkHandle benchmarks the injection into Pods of different sizes, where
+// the patch only contains the operations for the injected resources.
+func BenchmarkHandle(b *testing.B) {
+	for _, size := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("containers=%d", size), func(b *testing.B) {
+			injector := newBenchmarkInjector()
+			raw, err := json.Marshal(newBenchmarkPod(size, size))
+			if err != nil {
+				b.Fatal(err)
+			}
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Name:      "benchmark",
+					Namespace: "default",
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if res := injector.Handle(context.Background(), req); !res.Allowed {
+					b.Fatal(res.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPatchResponseFromRaw benchmarks the creation of a patch by
+// marshaling the whole injected Pod and comparing it with the raw Pod from the
+// request. It is used as baseline for BenchmarkHandle, because this was done
+// for each request before the patches were created for the changed fields
+// only.
+func BenchmarkPatchResponseFromRaw(b *testing.B) {
+	for _, size := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("containers=%d", size), func(b *testing.B) {
+			pod := newBenchmarkPod(size, size)
+			raw, err := json.Marshal(pod)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			injectedPod := pod.DeepCopy()
+			injectedPod.Spec.InitContainers = append(injectedPod.Spec.InitContainers, corev1.Container{Name: "setup", Image: "setup"})
+			injectedPod.Spec.Containers = append(injectedPod.Spec.Containers, corev1.Container{Name: "log-shipper", Image: "log-shipper"}, corev1.Container{Name: "proxy", Image: "proxy"})
+			injectedPod.Spec.Volumes = append(injectedPod.Spec.Volumes, corev1.Volume{Name: "logs"})
+			injectedPod.Annotations[annotationStatusKey] = "injected"
+
+			b.ReportAllocs()
+			for b.Loop() {
+				decodedPod := &corev1.Pod{}
+				if err := json.Unmarshal(raw, decodedPod); err != nil {
+					b.Fatal(err)
+				}
+				marshaledPod, err := json.Marshal(injectedPod)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if res := admission.PatchResponseFromRaw(raw, marshaledPod); !res.Allowed {
+					b.Fatal(res.Result.Message)
+				}
+			}
+		})
+	}
+}
+
+// newBenchmarkConfig returns a configuration with the given number of
+// injectors, where only the last injector matches the benchmark Pod.
+func newBenchmarkConfig(injectors int) *Config {
+	cfg := &Config{
+		Containers: []Container{{Container: corev1.Container{Name: "proxy", Image: "proxy"}}},
+	}
+
+	for i := range injectors {
+		app := fmt.Sprintf("app-%d", i)
+		if i == injectors-1 {
+			app = "benchmark"
+		}
+		cfg.Injectors = append(cfg.Injectors, InjectorData{
+			Selector: metav1.LabelSelector{
+				MatchLabels:      map[string]string{"app": app},
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"database", "cache"}}},
+			},
+			Containers: []string{"proxy"},
+		})
+	}
+
+	return cfg
+}
+
+// BenchmarkGetResourcesToInject benchmarks the matching of a Pod against
+// configurations with many injectors. The compiled configuration is created
+// like a configuration loaded from a file, while the selectors of the
+// uncompiled configuration are parsed for each request.
+func BenchmarkGetResourcesToInject(b *testing.B) {
+	for _, size := range []int{10, 100, 500} {
+		for _, compiled := range []bool{true, false} {
+			b.Run(fmt.Sprintf("injectors=%d/compiled=%t", size, compiled), func(b *testing.B) {
+				cfg := newBenchmarkConfig(size)
+				if compiled {
+					if err := cfg.compile(); err != nil {
+						b.Fatal(err)
+					}
+				}
+				injector := &Injector{Config: cfg}
+				pod := newBenchmarkPod(1, 1)
+				req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Name: pod.Name, Namespace: pod.Namespace}}
+
+				b.ReportAllocs()
+				for b.Loop() {
+					res, _, err := injector.getResourcesToInject(context.Background(), req, pod, cfg)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if res == nil || len(res.containers) != 1 {
+						b.Fatal("expected one container")
+					}
+				}
+			})
+		}
+	}
+}
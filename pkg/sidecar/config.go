@@ -1,31 +1,336 @@
 package sidecar
 
 import (
+	"fmt"
 	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	sigsjson "sigs.k8s.io/json"
 	"sigs.k8s.io/yaml"
 )
 
 type InjectorData struct {
-	Selector       metav1.LabelSelector `yaml:"selector"`
-	Containers     []string             `yaml:"container"`
-	InitContainers []string             `yaml:"initContainers"`
-	Volumes        []string             `yaml:"volumes"`
+	Name           string               `json:"name,omitempty"`
+	Selector       metav1.LabelSelector `json:"selector"`
+	Containers     []string             `json:"containers"`
+	InitContainers []string             `json:"initContainers"`
+	Volumes        []string             `json:"volumes"`
+
+	// NamespaceSelector can be used to select Pods based on the labels of their
+	// Namespace. If it is set, the selector and the namespace selector must
+	// both match for the injector to apply.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Priority defines the order in which the resources of multiple matching
+	// injectors are merged. The resources of injectors with a higher priority
+	// are injected first. Injectors with the same priority are merged in the
+	// order in which they are defined.
+	Priority int `json:"priority,omitempty"`
+
+	// Exclusive defines that no other injector should be applied to a Pod, when
+	// the injector matches the Pod. If multiple exclusive injectors are matching
+	// a Pod, only the one with the highest priority is applied.
+	Exclusive bool `json:"exclusive,omitempty"`
+
+	// NativeSidecars defines that the containers of the injector should be
+	// injected as native sidecar containers, which are init containers with the
+	// restart policy "Always". If the Kubernetes cluster doesn't support native
+	// sidecar containers, the containers are injected as regular containers.
+	NativeSidecars bool `json:"nativeSidecars,omitempty"`
+
+	// Mandatory defines that Pods can not opt out of the injector. If a Pod
+	// disables the injection or excludes a container of the injector via
+	// annotations, the Pod is rejected.
+	Mandatory bool `json:"mandatory,omitempty"`
+
+	// AppContainers defines mutations for the existing containers of a Pod,
+	// e.g. to mount a volume which is shared with an injected container.
+	AppContainers []AppContainerMutation `json:"appContainers,omitempty"`
+
+	// Pod defines mutations for the metadata and the specification of the
+	// Pod, e.g. additional labels or tolerations.
+	Pod *PodMutation `json:"pod,omitempty"`
+}
+
+// name returns the name of the injector, which is used to record the applied
+// injectors on a Pod. If the injector doesn't have a name, the index of the
+// injector in the configuration is used.
+func (i InjectorData) name(index int) string {
+	if i.Name != "" {
+		return i.Name
+	}
+	return fmt.Sprintf("injectors[%d]", index)
 }
 
+// ConflictStrategy defines what should happen, when a Pod already contains a
+// container or volume with the same name as the container or volume which
+// should be injected.
+type ConflictStrategy string
+
+const (
+	// ConflictStrategyFail rejects the Pod.
+	ConflictStrategyFail ConflictStrategy = "Fail"
+	// ConflictStrategySkip doesn't inject the container or volume and keeps
+	// the existing one.
+	ConflictStrategySkip ConflictStrategy = "Skip"
+	// ConflictStrategyReplace replaces the existing container or volume with
+	// the injected one.
+	ConflictStrategyReplace ConflictStrategy = "Replace"
+)
+
+var supportedConflictStrategies = []ConflictStrategy{ConflictStrategyFail, ConflictStrategySkip, ConflictStrategyReplace}
+
+// Container is the definition of a container, which can be injected into a
+// Pod.
+type Container struct {
+	corev1.Container `json:",inline"`
+
+	// OnConflict defines what should happen, when the Pod already contains a
+	// container with the same name. If it is not set, the default strategy
+	// from the configuration is used.
+	OnConflict ConflictStrategy `json:"onConflict,omitempty"`
+
+	// ResourceBounds limits the requests and limits, which can be set via the
+	// annotations of a Pod or Namespace.
+	ResourceBounds *ResourceBounds `json:"resourceBounds,omitempty"`
+
+	// Templates enables the rendering of the Go templates in the fields of
+	// the container. If it is not set, the fields are used as they are.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// ResourceBounds defines the minimum and maximum for the requests and limits
+// of a container. Requests and limits which are set via annotations and are
+// outside of the bounds are changed to the minimum or maximum.
+type ResourceBounds struct {
+	Min corev1.ResourceList `json:"min,omitempty"`
+	Max corev1.ResourceList `json:"max,omitempty"`
+}
+
+// Volume is the definition of a volume, which can be injected into a Pod.
+type Volume struct {
+	corev1.Volume `json:",inline"`
+
+	// OnConflict defines what should happen, when the Pod already contains a
+	// volume with the same name. If it is not set, the default strategy from
+	// the configuration is used.
+	OnConflict ConflictStrategy `json:"onConflict,omitempty"`
+
+	// Parameters are fields of the volume, which can be set via the
+	// annotations of a Pod, e.g. the name of the Secret.
+	Parameters []VolumeParameter `json:"parameters,omitempty"`
+
+	// Templates enables the rendering of the Go templates in the fields of
+	// the volume. If it is not set, the fields are used as they are.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// VolumeParameter sets a field of a volume to the value of an annotation of
+// the Pod. The value must match the pattern, so that a Pod can only use the
+// allowed values, e.g. the Secrets of its own team. If the annotation is not
+// set or the value is not allowed, the field from the definition is used.
+type VolumeParameter struct {
+	Annotation string               `json:"annotation"`
+	Field      VolumeParameterField `json:"field"`
+
+	// Pattern is a regular expression, which must match the complete value
+	// of the annotation.
+	Pattern string `json:"pattern"`
+}
+
+// VolumeParameterField is a field of a volume, which can be set via a
+// parameter. The fields of a projected volume are set for all sources of the
+// corresponding type.
+type VolumeParameterField string
+
+const (
+	// VolumeParameterSecretName sets the name of the Secret of a secret
+	// volume or of the secret sources of a projected volume.
+	VolumeParameterSecretName VolumeParameterField = "secretName"
+	// VolumeParameterConfigMapName sets the name of the ConfigMap of a
+	// configMap volume or of the configMap sources of a projected volume.
+	VolumeParameterConfigMapName VolumeParameterField = "configMapName"
+	// VolumeParameterAudience sets the audience of the service account token
+	// sources of a projected volume.
+	VolumeParameterAudience VolumeParameterField = "audience"
+	// VolumeParameterExpirationSeconds sets the expiration of the service
+	// account token sources of a projected volume.
+	VolumeParameterExpirationSeconds VolumeParameterField = "expirationSeconds"
+	// VolumeParameterSizeLimit sets the size limit of an emptyDir volume.
+	VolumeParameterSizeLimit VolumeParameterField = "sizeLimit"
+)
+
+var supportedVolumeParameterFields = []VolumeParameterField{VolumeParameterSecretName, VolumeParameterConfigMapName, VolumeParameterAudience, VolumeParameterExpirationSeconds, VolumeParameterSizeLimit}
+
+// AppContainerMutation defines volume mounts, environment variables and
+// envFrom entries, which are added to the existing containers of a Pod.
+type AppContainerMutation struct {
+	// Containers is a list of patterns for the names of the containers, which
+	// should be mutated. The patterns are matched via "path.Match". If it is
+	// empty, all containers of the Pod are mutated.
+	Containers []string `json:"containers,omitempty"`
+
+	VolumeMounts []corev1.VolumeMount   `json:"volumeMounts,omitempty"`
+	Env          []corev1.EnvVar        `json:"env,omitempty"`
+	EnvFrom      []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// OnConflict defines what should happen, when a container already
+	// contains a volume mount with the same mount path or an environment
+	// variable with the same name. If it is not set, the default strategy
+	// from the configuration is used.
+	OnConflict ConflictStrategy `json:"onConflict,omitempty"`
+
+	// Templates enables the rendering of the Go templates in the fields of
+	// the mutation. If it is not set, the fields are used as they are.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// PodMutation defines changes for the metadata and the specification of a
+// Pod. Lists are merged with the values of the Pod, so that existing entries
+// are kept:
+//
+//   - Labels and annotations are added. If the Pod already contains a key with
+//     a different value, the conflict strategy is applied.
+//   - Image pull secrets are added, when the Pod doesn't contain a secret with
+//     the same name.
+//   - Tolerations are added, when the Pod doesn't contain the same toleration.
+//   - Host aliases are added. If the Pod already contains an alias for the same
+//     IP, the missing hostnames are added to the existing alias.
+//   - The nameservers and searches of the DNS config are added. If the Pod
+//     already contains an option with the same name and a different value, the
+//     conflict strategy is applied.
+//   - The process namespace sharing is set. If the Pod already sets it to a
+//     different value, the conflict strategy is applied.
+//   - The termination grace period is raised to the given value, but never
+//     lowered, so that the grace periods of all injectors are respected.
+type PodMutation struct {
+	Labels                        map[string]string             `json:"labels,omitempty"`
+	Annotations                   map[string]string             `json:"annotations,omitempty"`
+	ImagePullSecrets              []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	ShareProcessNamespace         *bool                         `json:"shareProcessNamespace,omitempty"`
+	HostAliases                   []corev1.HostAlias            `json:"hostAliases,omitempty"`
+	DNSConfig                     *corev1.PodDNSConfig          `json:"dnsConfig,omitempty"`
+	Tolerations                   []corev1.Toleration           `json:"tolerations,omitempty"`
+	TerminationGracePeriodSeconds *int64                        `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// OnConflict defines what should happen, when the Pod already contains a
+	// label, annotation, DNS option or process namespace sharing setting with
+	// a different value. If it is not set, the default strategy from the
+	// configuration is used.
+	OnConflict ConflictStrategy `json:"onConflict,omitempty"`
+
+	// Templates enables the rendering of the Go templates in the labels,
+	// annotations and other fields of the mutation. If it is not set, the
+	// fields are used as they are.
+	Templates bool `json:"templates,omitempty"`
+}
+
+// EnvironmentVariable defines an environment variable, which is added to the
+// injected containers. The value of the environment variable is taken from
+// exactly one source: an annotation or label of the Pod, a constant value or a
+// field or resource of the Pod via the downward API.
 type EnvironmentVariable struct {
-	Name       string `yaml:"name"`
-	Container  string `yaml:"container"`
-	Annotation string `yaml:"annotation"`
+	Name string `json:"name"`
+
+	// Container and Containers are the names of the injected containers,
+	// which get the environment variable. If both are empty, the environment
+	// variable is added to all injected containers.
+	Container  string   `json:"container,omitempty"`
+	Containers []string `json:"containers,omitempty"`
+
+	Annotation       string                        `json:"annotation,omitempty"`
+	Label            string                        `json:"label,omitempty"`
+	Value            string                        `json:"value,omitempty"`
+	FieldRef         *corev1.ObjectFieldSelector   `json:"fieldRef,omitempty"`
+	ResourceFieldRef *corev1.ResourceFieldSelector `json:"resourceFieldRef,omitempty"`
+
+	// Default is used, when the annotation or label is not set or empty. If
+	// no default is set, the environment variable is not added in this case.
+	Default string `json:"default,omitempty"`
+
+	// Prefix is added to the value of the annotation, label or constant
+	// value. Template is rendered with the same data as the templates of the
+	// containers and volumes and the value as ".Value". The prefix is added
+	// before the template is rendered.
+	Prefix   string `json:"prefix,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+// targets returns true, when the environment variable should be added to the
+// container with the given name.
+func (e EnvironmentVariable) targets(name string) bool {
+	if e.Container == "" && len(e.Containers) == 0 {
+		return true
+	}
+	return e.Container == name || slices.Contains(e.Containers, name)
+}
+
+// source returns a description of the source of the environment variable,
+// which is used in explanations and messages.
+func (e EnvironmentVariable) source() string {
+	switch {
+	case e.Annotation != "":
+		return "annotation " + e.Annotation
+	case e.Label != "":
+		return "label " + e.Label
+	case e.FieldRef != nil:
+		return "fieldRef " + e.FieldRef.FieldPath
+	case e.ResourceFieldRef != nil:
+		return "resourceFieldRef " + e.ResourceFieldRef.Resource
+	default:
+		return "value"
+	}
 }
 
 type Config struct {
-	Injectors            []InjectorData        `yaml:"injectors"`
-	Containers           []corev1.Container    `yaml:"containers"`
-	Volumes              []corev1.Volume       `yaml:"volumes"`
-	EnvironmentVariables []EnvironmentVariable `yaml:"environmentVariables"`
+	Injectors            []InjectorData        `json:"injectors"`
+	Containers           []Container           `json:"containers"`
+	Volumes              []Volume              `json:"volumes"`
+	EnvironmentVariables []EnvironmentVariable `json:"environmentVariables"`
+
+	// OnConflict is the default strategy for containers and volumes, which
+	// do not define their own strategy. If it is not set, Pods with a
+	// conflicting container or volume are rejected.
+	OnConflict ConflictStrategy `json:"onConflict,omitempty"`
+
+	// ProtectAnnotations denies updates of injected Pods, which remove or
+	// change the annotations of the sidecar injector.
+	ProtectAnnotations bool `json:"protectAnnotations,omitempty"`
+
+	// Strict denies Pods with annotations of the sidecar injector, which are
+	// ignored or can not be parsed. If it is not set, the Pods are injected
+	// and the problems are returned as warnings in the admission response.
+	Strict bool `json:"strict,omitempty"`
+
+	// index is compiled when the configuration is loaded and is used to
+	// match Pods and to get containers and volumes by their name.
+	index *configIndex
+
+	// customResources caches the configuration, which is merged with the
+	// SidecarTemplates and SidecarInjectors. It is created together with the
+	// index, so that the cache is discarded when the configuration is
+	// reloaded.
+	customResources *customResourcesCache
+}
+
+// conflictStrategy returns the given strategy of a container or volume. If the
+// strategy is empty, the default strategy of the configuration is returned.
+func (c *Config) conflictStrategy(strategy ConflictStrategy) ConflictStrategy {
+	if strategy != "" {
+		return strategy
+	}
+	if c.OnConflict != "" {
+		return c.OnConflict
+	}
+	return ConflictStrategyFail
 }
 
 func LoadConfig(file string) (*Config, error) {
@@ -34,10 +339,481 @@ func LoadConfig(file string) (*Config, error) {
 		return nil, err
 	}
 
+	return parseConfig(configContent)
+}
+
+// parseConfig parses the given content of a configuration file and validates
+// the parsed configuration. Unknown fields in the configuration are reported
+// together with all other validation errors in the returned error, which can
+// be converted to an "utilerrors.Aggregate" to get all problems.
+func parseConfig(configContent []byte) (*Config, error) {
+	jsonContent, err := yaml.YAMLToJSON(configContent)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &Config{}
-	if err := yaml.Unmarshal(configContent, cfg); err != nil {
+	strictErrs, err := sigsjson.UnmarshalStrict(jsonContent, cfg, sigsjson.DisallowUnknownFields, sigsjson.DisallowDuplicateFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	errs = append(errs, strictErrs...)
+	for _, err := range cfg.validate() {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+
+	if err := cfg.compile(); err != nil {
 		return nil, err
 	}
 
 	return cfg, nil
 }
+
+// Validate checks if the configuration can be used by the injector. The
+// returned error is an "utilerrors.Aggregate", which contains all problems
+// found in the configuration.
+func (c *Config) Validate() error {
+	return c.validate().ToAggregate()
+}
+
+// validate checks all cross-references in the configuration, that the names
+// of all containers, volumes and environment variables are unique and that
+// the label selectors and resources of the containers are valid.
+func (c *Config) validate() field.ErrorList {
+	var allErrs field.ErrorList
+
+	containers := make(map[string]bool)
+	for index, container := range c.Containers {
+		fldPath := field.NewPath("containers").Index(index)
+
+		if container.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(container.Name) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), container.Name, msg))
+			}
+			if containers[container.Name] {
+				allErrs = append(allErrs, field.Duplicate(fldPath.Child("name"), container.Name))
+			}
+			containers[container.Name] = true
+		}
+
+		if container.Image == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("image"), ""))
+		}
+
+		if container.RestartPolicy != nil && *container.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("restartPolicy"), *container.RestartPolicy, []corev1.ContainerRestartPolicy{corev1.ContainerRestartPolicyAlways}))
+		}
+
+		allErrs = append(allErrs, validateResources(container.Resources, fldPath.Child("resources"))...)
+		if container.ResourceBounds != nil {
+			allErrs = append(allErrs, validateResourceBounds(*container.ResourceBounds, container.Resources, fldPath)...)
+		}
+		allErrs = append(allErrs, validateConflictStrategy(container.OnConflict, fldPath.Child("onConflict"))...)
+		if container.Templates {
+			allErrs = append(allErrs, make(templateCache).add(&container.Container, fldPath)...)
+		}
+	}
+
+	volumes := make(map[string]bool)
+	for index, volume := range c.Volumes {
+		fldPath := field.NewPath("volumes").Index(index)
+
+		if volume.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Label(volume.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("name"), volume.Name, msg))
+		}
+		if volumes[volume.Name] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("name"), volume.Name))
+		}
+		volumes[volume.Name] = true
+
+		allErrs = append(allErrs, validateConflictStrategy(volume.OnConflict, fldPath.Child("onConflict"))...)
+		allErrs = append(allErrs, validateVolumeParameters(volume, fldPath.Child("parameters"))...)
+		if volume.Templates {
+			allErrs = append(allErrs, make(templateCache).add(&volume.Volume, fldPath)...)
+		}
+	}
+
+	injectors := make(map[string]bool)
+	for index, injector := range c.Injectors {
+		fldPath := field.NewPath("injectors").Index(index)
+
+		if injector.Name != "" {
+			if injectors[injector.Name] {
+				allErrs = append(allErrs, field.Duplicate(fldPath.Child("name"), injector.Name))
+			}
+			injectors[injector.Name] = true
+		}
+
+		if _, err := metav1.LabelSelectorAsSelector(&injector.Selector); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("selector"), injector.Selector, err.Error()))
+		}
+		if injector.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(injector.NamespaceSelector); err != nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("namespaceSelector"), injector.NamespaceSelector, err.Error()))
+			}
+		}
+
+		allErrs = append(allErrs, validateReferences(injector.Containers, containers, fldPath.Child("containers"))...)
+		allErrs = append(allErrs, validateReferences(injector.InitContainers, containers, fldPath.Child("initContainers"))...)
+		allErrs = append(allErrs, validateReferences(injector.Volumes, volumes, fldPath.Child("volumes"))...)
+
+		for mutationIndex, mutation := range injector.AppContainers {
+			allErrs = append(allErrs, validateAppContainerMutation(mutation, fldPath.Child("appContainers").Index(mutationIndex))...)
+			if mutation.Templates {
+				allErrs = append(allErrs, make(templateCache).add(&mutation, fldPath.Child("appContainers").Index(mutationIndex))...)
+			}
+		}
+		if injector.Pod != nil {
+			allErrs = append(allErrs, validatePodMutation(*injector.Pod, fldPath.Child("pod"))...)
+			if injector.Pod.Templates {
+				allErrs = append(allErrs, make(templateCache).add(injector.Pod, fldPath.Child("pod"))...)
+			}
+		}
+	}
+
+	environmentVariables := make(map[string]bool)
+	for index, envVar := range c.EnvironmentVariables {
+		fldPath := field.NewPath("environmentVariables").Index(index)
+
+		if envVar.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+		}
+		allErrs = append(allErrs, validateEnvironmentVariableSource(envVar, fldPath)...)
+
+		// The environment variables for all containers are recorded with an
+		// empty container name, so that they are only reported as duplicates
+		// of each other.
+		targets := envVar.Containers
+		if envVar.Container != "" {
+			targets = append([]string{envVar.Container}, targets...)
+			if !containers[envVar.Container] {
+				allErrs = append(allErrs, field.NotFound(fldPath.Child("container"), envVar.Container))
+			}
+		}
+		allErrs = append(allErrs, validateReferences(envVar.Containers, containers, fldPath.Child("containers"))...)
+		if len(targets) == 0 {
+			targets = []string{""}
+		}
+
+		for _, target := range targets {
+			key := target + "/" + envVar.Name
+			if environmentVariables[key] {
+				allErrs = append(allErrs, field.Duplicate(fldPath.Child("name"), envVar.Name))
+				break
+			}
+			environmentVariables[key] = true
+		}
+	}
+
+	allErrs = append(allErrs, validateConflictStrategy(c.OnConflict, field.NewPath("onConflict"))...)
+
+	return allErrs
+}
+
+// validateEnvironmentVariableSource checks that the environment variable has
+// exactly one source, that the default, prefix and template are only used for
+// sources with a value and that the template can be parsed.
+func validateEnvironmentVariableSource(envVar EnvironmentVariable, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	var sources []string
+	for name, set := range map[string]bool{
+		"annotation":       envVar.Annotation != "",
+		"label":            envVar.Label != "",
+		"value":            envVar.Value != "",
+		"fieldRef":         envVar.FieldRef != nil,
+		"resourceFieldRef": envVar.ResourceFieldRef != nil,
+	} {
+		if set {
+			sources = append(sources, name)
+		}
+	}
+	sort.Strings(sources)
+
+	switch {
+	case len(sources) == 0:
+		allErrs = append(allErrs, field.Required(fldPath, "one of annotation, label, value, fieldRef or resourceFieldRef must be set"))
+	case len(sources) > 1:
+		allErrs = append(allErrs, field.Invalid(fldPath, sources, "only one of annotation, label, value, fieldRef or resourceFieldRef can be set"))
+	}
+
+	if envVar.FieldRef != nil && envVar.FieldRef.FieldPath == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("fieldRef", "fieldPath"), ""))
+	}
+	if envVar.ResourceFieldRef != nil && envVar.ResourceFieldRef.Resource == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resourceFieldRef", "resource"), ""))
+	}
+
+	if envVar.Default != "" && envVar.Annotation == "" && envVar.Label == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("default"), envVar.Default, "can only be used with an annotation or label"))
+	}
+	if envVar.FieldRef != nil || envVar.ResourceFieldRef != nil {
+		if envVar.Prefix != "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("prefix"), envVar.Prefix, "can not be used with fieldRef or resourceFieldRef"))
+		}
+		if envVar.Template != "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("template"), envVar.Template, "can not be used with fieldRef or resourceFieldRef"))
+		}
+	}
+	if envVar.Template != "" {
+		if _, err := parseTemplate(envVar.Template, fldPath.Child("template")); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("template"), envVar.Template, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateVolumeParameters checks that the annotation, field and pattern of
+// each parameter are set, that the pattern can be compiled, that each field is
+// only set once and that the volume has a source with the field.
+func validateVolumeParameters(volume Volume, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	fields := make(map[VolumeParameterField]bool)
+	for index, parameter := range volume.Parameters {
+		fldPath := fldPath.Index(index)
+
+		if parameter.Annotation == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("annotation"), ""))
+		} else {
+			for _, msg := range validation.IsQualifiedName(parameter.Annotation) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("annotation"), parameter.Annotation, msg))
+			}
+		}
+
+		switch {
+		case parameter.Field == "":
+			allErrs = append(allErrs, field.Required(fldPath.Child("field"), ""))
+		case !slices.Contains(supportedVolumeParameterFields, parameter.Field):
+			allErrs = append(allErrs, field.NotSupported(fldPath.Child("field"), parameter.Field, supportedVolumeParameterFields))
+		case fields[parameter.Field]:
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("field"), parameter.Field))
+		case !parameter.Field.supports(volume.Volume):
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("field"), parameter.Field, "the volume doesn't have a source with this field"))
+		}
+		fields[parameter.Field] = true
+
+		if parameter.Pattern == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("pattern"), ""))
+		} else if _, err := parameter.compile(); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("pattern"), parameter.Pattern, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validateConflictStrategy checks that the given strategy is empty or one of
+// the supported strategies.
+func validateConflictStrategy(strategy ConflictStrategy, fldPath *field.Path) field.ErrorList {
+	if strategy == "" || slices.Contains(supportedConflictStrategies, strategy) {
+		return nil
+	}
+
+	return field.ErrorList{field.NotSupported(fldPath, strategy, supportedConflictStrategies)}
+}
+
+// validateAppContainerMutation checks that the container name patterns of the
+// mutation are valid and that the mount paths and the names of the
+// environment variables are set and unique.
+func validateAppContainerMutation(mutation AppContainerMutation, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for index, pattern := range mutation.Containers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("containers").Index(index), pattern, err.Error()))
+		}
+	}
+
+	mountPaths := make(map[string]bool)
+	for index, volumeMount := range mutation.VolumeMounts {
+		if volumeMount.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("volumeMounts").Index(index).Child("name"), ""))
+		}
+		if volumeMount.MountPath == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("volumeMounts").Index(index).Child("mountPath"), ""))
+			continue
+		}
+		if mountPaths[volumeMount.MountPath] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("volumeMounts").Index(index).Child("mountPath"), volumeMount.MountPath))
+		}
+		mountPaths[volumeMount.MountPath] = true
+	}
+
+	names := make(map[string]bool)
+	for index, envVar := range mutation.Env {
+		if envVar.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("env").Index(index).Child("name"), ""))
+			continue
+		}
+		if names[envVar.Name] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("env").Index(index).Child("name"), envVar.Name))
+		}
+		names[envVar.Name] = true
+	}
+
+	allErrs = append(allErrs, validateConflictStrategy(mutation.OnConflict, fldPath.Child("onConflict"))...)
+
+	return allErrs
+}
+
+// validatePodMutation checks that the keys of the labels and annotations are
+// valid and do not use the prefix of the sidecar injector, that the names of
+// the image pull secrets and the IPs of the host aliases are set and that the
+// termination grace period is not negative.
+func validatePodMutation(mutation PodMutation, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for _, key := range sortedKeys(mutation.Labels) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("labels").Key(key), key, msg))
+		}
+	}
+	for _, key := range sortedKeys(mutation.Annotations) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("annotations").Key(key), key, msg))
+		}
+		if key == annotationInjectKey || strings.HasPrefix(key, annotationInjectKey+"/") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("annotations").Key(key), key, "annotations of the sidecar injector can not be set"))
+		}
+	}
+
+	for index, secret := range mutation.ImagePullSecrets {
+		if secret.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("imagePullSecrets").Index(index).Child("name"), ""))
+		}
+	}
+	for index, hostAlias := range mutation.HostAliases {
+		if hostAlias.IP == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("hostAliases").Index(index).Child("ip"), ""))
+		}
+	}
+	if mutation.DNSConfig != nil {
+		for index, option := range mutation.DNSConfig.Options {
+			if option.Name == "" {
+				allErrs = append(allErrs, field.Required(fldPath.Child("dnsConfig", "options").Index(index).Child("name"), ""))
+			}
+		}
+	}
+	if mutation.TerminationGracePeriodSeconds != nil && *mutation.TerminationGracePeriodSeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("terminationGracePeriodSeconds"), *mutation.TerminationGracePeriodSeconds, "must be greater than or equal to 0"))
+	}
+
+	allErrs = append(allErrs, validateConflictStrategy(mutation.OnConflict, fldPath.Child("onConflict"))...)
+	if len(mutation.Labels) > 0 && mutation.OnConflict == ConflictStrategyReplace {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("onConflict"), mutation.OnConflict, "existing labels can not be replaced, use Fail or Skip"))
+	}
+
+	return allErrs
+}
+
+// validateReferences checks that all given names are contained in the map of
+// defined names.
+func validateReferences(names []string, defined map[string]bool, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for index, name := range names {
+		if !defined[name] {
+			allErrs = append(allErrs, field.NotFound(fldPath.Index(index), name))
+		}
+	}
+
+	return allErrs
+}
+
+// validateResources checks that all resource quantities are not negative and
+// that the requests are not greater than the limits.
+func validateResources(resources corev1.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for _, name := range sortedResourceNames(resources.Limits) {
+		quantity := resources.Limits[name]
+		if quantity.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("limits").Key(string(name)), quantity.String(), "must be greater than or equal to 0"))
+		}
+	}
+
+	for _, name := range sortedResourceNames(resources.Requests) {
+		quantity := resources.Requests[name]
+		if quantity.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), quantity.String(), "must be greater than or equal to 0"))
+		}
+		if limit, ok := resources.Limits[name]; ok && quantity.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), quantity.String(), fmt.Sprintf("must be less than or equal to %s limit of %s", name, limit.String())))
+		}
+	}
+
+	return allErrs
+}
+
+// validateResourceBounds checks that the minimum and maximum are not negative,
+// that the minimum is not greater than the maximum and that the requests and
+// limits of the container are within the bounds.
+func validateResourceBounds(bounds ResourceBounds, resources corev1.ResourceRequirements, containerPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	fldPath := containerPath.Child("resourceBounds")
+
+	for _, name := range sortedResourceNames(bounds.Min) {
+		quantity := bounds.Min[name]
+		if quantity.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("min").Key(string(name)), quantity.String(), "must be greater than or equal to 0"))
+		}
+		if max, ok := bounds.Max[name]; ok && quantity.Cmp(max) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("min").Key(string(name)), quantity.String(), fmt.Sprintf("must be less than or equal to %s maximum of %s", name, max.String())))
+		}
+	}
+
+	for _, name := range sortedResourceNames(bounds.Max) {
+		quantity := bounds.Max[name]
+		if quantity.Sign() < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("max").Key(string(name)), quantity.String(), "must be greater than or equal to 0"))
+		}
+	}
+
+	for _, kind := range []string{"requests", "limits"} {
+		list := resources.Requests
+		if kind == "limits" {
+			list = resources.Limits
+		}
+
+		for _, name := range sortedResourceNames(list) {
+			quantity := list[name]
+			if min, ok := bounds.Min[name]; ok && quantity.Cmp(min) < 0 {
+				allErrs = append(allErrs, field.Invalid(containerPath.Child("resources", kind).Key(string(name)), quantity.String(), fmt.Sprintf("must be greater than or equal to %s minimum of %s", name, min.String())))
+			}
+			if max, ok := bounds.Max[name]; ok && quantity.Cmp(max) > 0 {
+				allErrs = append(allErrs, field.Invalid(containerPath.Child("resources", kind).Key(string(name)), quantity.String(), fmt.Sprintf("must be less than or equal to %s maximum of %s", name, max.String())))
+			}
+		}
+	}
+
+	return allErrs
+}
+
+// sortedResourceNames returns the names of the given resources in a sorted
+// order, so that validation errors are always reported in the same order.
+func sortedResourceNames(resources corev1.ResourceList) []corev1.ResourceName {
+	names := make([]corev1.ResourceName, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		return names[a] < names[b]
+	})
+
+	return names
+}
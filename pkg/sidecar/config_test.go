@@ -0,0 +1,315 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+var _ = Describe("Config", func() {
+	Context("Validating the configuration", func() {
+		It("Should accept valid configuration", func() {
+			cfg, err := parseConfig([]byte(`
+injectors:
+  - selector:
+      matchLabels:
+        app: test
+    containers: [test-container]
+    initContainers: [test-initcontainer]
+    volumes: [test-volume]
+containers:
+  - name: test-container
+    image: test-image
+    resources:
+      requests:
+        cpu: 100m
+      limits:
+        cpu: 200m
+  - name: test-initcontainer
+    image: test-image
+    onConflict: Skip
+volumes:
+  - name: test-volume
+    emptyDir: {}
+    onConflict: Replace
+environmentVariables:
+  - name: TEST
+    container: test-container
+    annotation: sidecar-injector.ricoberger.de/test
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(cfg.Injectors)).To(Equal(1))
+			Expect(cfg.Injectors[0].Containers).To(Equal([]string{"test-container"}))
+			Expect(cfg.Containers[0].Image).To(Equal("test-image"))
+			Expect(cfg.Containers[1].OnConflict).To(Equal(ConflictStrategySkip))
+			Expect(cfg.Volumes[0].EmptyDir).NotTo(BeNil())
+			Expect(cfg.Volumes[0].OnConflict).To(Equal(ConflictStrategyReplace))
+		})
+
+		It("Should report all problems of invalid configuration", func() {
+			_, err := parseConfig([]byte(`
+injectors:
+  - selector:
+      matchExpressions:
+        - key: app
+          operator: Invalid
+    containers: [missing-container]
+    initContainers: [missing-initcontainer]
+    volumes: [missing-volume]
+    unknown: field
+containers:
+  - name: test-container
+    image: test-image
+    resources:
+      requests:
+        cpu: 300m
+      limits:
+        cpu: 200m
+  - name: test-container
+    restartPolicy: OnFailure
+environmentVariables:
+  - name: TEST
+    container: missing-container
+    annotation: sidecar-injector.ricoberger.de/test
+onConflict: Ignore
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`unknown field "injectors[0].unknown"`,
+				`containers[0].resources.requests[cpu]: Invalid value: "300m": must be less than or equal to cpu limit of 200m`,
+				`containers[1].name: Duplicate value: "test-container"`,
+				`containers[1].image: Required value`,
+				`containers[1].restartPolicy: Unsupported value: "OnFailure": supported values: "Always"`,
+				`injectors[0].selector: Invalid value: {"matchExpressions":[{"key":"app","operator":"Invalid"}]}: "Invalid" is not a valid label selector operator`,
+				`injectors[0].containers[0]: Not found: "missing-container"`,
+				`injectors[0].initContainers[0]: Not found: "missing-initcontainer"`,
+				`injectors[0].volumes[0]: Not found: "missing-volume"`,
+				`environmentVariables[0].container: Not found: "missing-container"`,
+				`onConflict: Unsupported value: "Ignore": supported values: "Fail", "Skip", "Replace"`,
+			))
+		})
+
+		It("Should report invalid app container mutations", func() {
+			_, err := parseConfig([]byte(`
+injectors:
+  - selector: {}
+    appContainers:
+      - containers: ["app-["]
+        volumeMounts:
+          - name: socket
+            mountPath: /var/run
+          - name: other
+            mountPath: /var/run
+        env:
+          - value: test
+        onConflict: Ignore
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`injectors[0].appContainers[0].containers[0]: Invalid value: "app-[": syntax error in pattern`,
+				`injectors[0].appContainers[0].volumeMounts[1].mountPath: Duplicate value: "/var/run"`,
+				`injectors[0].appContainers[0].env[0].name: Required value`,
+				`injectors[0].appContainers[0].onConflict: Unsupported value: "Ignore": supported values: "Fail", "Skip", "Replace"`,
+			))
+		})
+
+		It("Should report invalid resource bounds", func() {
+			_, err := parseConfig([]byte(`
+containers:
+  - name: test-container
+    image: test-image
+    resources:
+      requests:
+        cpu: 10m
+    resourceBounds:
+      min:
+        cpu: 50m
+        memory: 2Gi
+      max:
+        memory: 1Gi
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`containers[0].resourceBounds.min[memory]: Invalid value: "2Gi": must be less than or equal to memory maximum of 1Gi`,
+				`containers[0].resources.requests[cpu]: Invalid value: "10m": must be greater than or equal to cpu minimum of 50m`,
+			))
+		})
+
+		It("Should report invalid environment variables", func() {
+			_, err := parseConfig([]byte(`
+containers:
+  - name: test-container
+    image: test-image
+environmentVariables:
+  - name: MISSING_SOURCE
+  - name: MULTIPLE_SOURCES
+    annotation: example.com/test
+    value: test
+  - name: FIELD_REF
+    containers: [missing-container]
+    fieldRef:
+      fieldPath: metadata.name
+    prefix: test-
+  - name: TEMPLATE
+    value: test
+    default: test
+    template: "{{ .Value"
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`environmentVariables[0]: Required value: one of annotation, label, value, fieldRef or resourceFieldRef must be set`,
+				`environmentVariables[1]: Invalid value: ["annotation","value"]: only one of annotation, label, value, fieldRef or resourceFieldRef can be set`,
+				`environmentVariables[2].prefix: Invalid value: "test-": can not be used with fieldRef or resourceFieldRef`,
+				`environmentVariables[2].containers[0]: Not found: "missing-container"`,
+				`environmentVariables[3].default: Invalid value: "test": can only be used with an annotation or label`,
+				`environmentVariables[3].template: Invalid value: "{{ .Value": template: environmentVariables[3].template:1: unclosed action`,
+			))
+		})
+
+		It("Should report invalid volume parameters", func() {
+			_, err := parseConfig([]byte(`
+volumes:
+  - name: credentials
+    secret:
+      secretName: default-credentials
+    parameters:
+      - annotation: example.com/credentials
+        field: secretName
+        pattern: "team-a-.*"
+      - annotation: example.com/size
+        field: sizeLimit
+        pattern: ".*"
+      - field: unknown
+        pattern: "(invalid"
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`volumes[0].parameters[1].field: Invalid value: "sizeLimit": the volume doesn't have a source with this field`,
+				`volumes[0].parameters[2].annotation: Required value`,
+				`volumes[0].parameters[2].field: Unsupported value: "unknown": supported values: "secretName", "configMapName", "audience", "expirationSeconds", "sizeLimit"`,
+				"volumes[0].parameters[2].pattern: Invalid value: \"(invalid\": error parsing regexp: missing closing ): `^(?:(invalid)$`",
+			))
+		})
+
+		It("Should report invalid pod mutations", func() {
+			_, err := parseConfig([]byte(`
+injectors:
+  - selector:
+      matchLabels:
+        app: test
+    pod:
+      labels:
+        invalid/label/key: "true"
+      annotations:
+        sidecar-injector.ricoberger.de/containers: proxy
+      imagePullSecrets:
+        - name: ""
+      hostAliases:
+        - hostnames: [proxy.local]
+      terminationGracePeriodSeconds: -1
+      onConflict: Merge
+`))
+			Expect(err).To(HaveOccurred())
+
+			agg, ok := err.(utilerrors.Aggregate)
+			Expect(ok).To(BeTrue())
+
+			var messages []string
+			for _, err := range agg.Errors() {
+				messages = append(messages, err.Error())
+			}
+
+			Expect(messages).To(ConsistOf(
+				`injectors[0].pod.labels[invalid/label/key]: Invalid value: "invalid/label/key": a valid label key must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyName',  or 'my.name',  or '123-abc', regex used for validation is '([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]') with an optional DNS subdomain prefix and '/' (e.g. 'example.com/MyName')`,
+				`injectors[0].pod.annotations[sidecar-injector.ricoberger.de/containers]: Invalid value: "sidecar-injector.ricoberger.de/containers": annotations of the sidecar injector can not be set`,
+				`injectors[0].pod.imagePullSecrets[0].name: Required value`,
+				`injectors[0].pod.hostAliases[0].ip: Required value`,
+				`injectors[0].pod.terminationGracePeriodSeconds: Invalid value: -1: must be greater than or equal to 0`,
+				`injectors[0].pod.onConflict: Unsupported value: "Merge": supported values: "Fail", "Skip", "Replace"`,
+			))
+		})
+
+		It("Should not allow to replace labels of the Pod", func() {
+			_, err := parseConfig([]byte(`
+injectors:
+  - selector:
+      matchLabels:
+        app: test
+    pod:
+      labels:
+        mesh: enabled
+      onConflict: Replace
+`))
+			Expect(err).To(MatchError(`injectors[0].pod.onConflict: Invalid value: "Replace": existing labels can not be replaced, use Fail or Skip`))
+
+			_, err = parseConfig([]byte(`
+injectors:
+  - selector:
+      matchLabels:
+        app: test
+    pod:
+      annotations:
+        example.com/owner: team
+      onConflict: Replace
+`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Should fail for invalid resource quantities", func() {
+			_, err := parseConfig([]byte(`
+containers:
+  - name: test-container
+    image: test-image
+    resources:
+      requests:
+        cpu: invalid
+`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,157 @@
+package sidecar
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// statusRequest is the only request, which is handled by the
+// StatusReconciler. Since the status of each SidecarTemplate depends on all
+// SidecarInjectors and the matched Pods, we always update the status of all
+// resources at once.
+var statusRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "status"}}
+
+// StatusReconciler updates the status of all SidecarTemplates and
+// SidecarInjectors, when one of the resources or a Pod is changed. The status
+// contains a "Valid" condition, which reports if the resource can be used by
+// the webhook and the number of Pods, where the resource was injected.
+type StatusReconciler struct {
+	Client   client.Client
+	Config   *Config
+	Reloader *ConfigReloader
+}
+
+func (r *StatusReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	templates := &v1alpha1.SidecarTemplateList{}
+	if err := r.Client.List(ctx, templates); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	injectors := &v1alpha1.SidecarInjectorList{}
+	if err := r.Client.List(ctx, injectors); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	cfg := r.Config
+	if r.Reloader != nil {
+		cfg = r.Reloader.Config()
+	}
+
+	_, templateErrs, injectorErrs := mergeCustomResources(cfg, templates.Items, injectors.Items)
+
+	// Count the injected Pods for each valid SidecarInjector and remember the
+	// matched Pods for each referenced SidecarTemplate, so that a Pod which is
+	// matched by multiple injectors is only counted once per template. The
+	// injectors which were applied to a Pod are recorded in the
+	// `sidecar-injector.ricoberger.de/injectors` annotation.
+	templatePods := make(map[string]map[types.UID]bool)
+	injectorPods := make(map[string]int32)
+
+	for _, injector := range injectors.Items {
+		if _, ok := injectorErrs[injector.Name]; ok {
+			continue
+		}
+
+		for _, pod := range pods.Items {
+			if !slices.Contains(strings.Split(pod.Annotations[annotationInjectorsKey], ","), injector.Name) {
+				continue
+			}
+
+			injectorPods[injector.Name]++
+			for _, templateName := range injector.Spec.Templates {
+				if templatePods[templateName] == nil {
+					templatePods[templateName] = make(map[types.UID]bool)
+				}
+				templatePods[templateName][pod.UID] = true
+			}
+		}
+	}
+
+	for _, template := range templates.Items {
+		status := template.Status.DeepCopy()
+		status.MatchedPods = int32(len(templatePods[template.Name]))
+		setValidCondition(&status.Conditions, template.Generation, templateErrs[template.Name])
+
+		if equality.Semantic.DeepEqual(status, &template.Status) {
+			continue
+		}
+
+		template.Status = *status
+		if err := r.Client.Status().Update(ctx, &template); err != nil {
+			log.Error(err, "Failed to update status of template.", "name", template.Name)
+			return reconcile.Result{}, err
+		}
+	}
+
+	for _, injector := range injectors.Items {
+		status := injector.Status.DeepCopy()
+		status.MatchedPods = injectorPods[injector.Name]
+		setValidCondition(&status.Conditions, injector.Generation, injectorErrs[injector.Name])
+
+		if equality.Semantic.DeepEqual(status, &injector.Status) {
+			continue
+		}
+
+		injector.Status = *status
+		if err := r.Client.Status().Update(ctx, &injector); err != nil {
+			log.Error(err, "Failed to update status of injector.", "name", injector.Name)
+			return reconcile.Result{}, err
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// SetupWithManager registers the StatusReconciler in the given manager. The
+// reconciler watches all SidecarTemplates, SidecarInjectors and Pods and
+// always enqueues the same request for all of them.
+func (r *StatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	enqueueStatusRequest := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{statusRequest}
+	})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("status").
+		Watches(&v1alpha1.SidecarTemplate{}, enqueueStatusRequest).
+		Watches(&v1alpha1.SidecarInjector{}, enqueueStatusRequest).
+		Watches(&corev1.Pod{}, enqueueStatusRequest).
+		Complete(r)
+}
+
+// setValidCondition sets the "Valid" condition in the given list of
+// conditions, based on the provided validation error.
+func setValidCondition(conditions *[]metav1.Condition, generation int64, err error) {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionTypeValid,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             "Valid",
+		Message:            "The resource is valid.",
+	}
+
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	}
+
+	meta.SetStatusCondition(conditions, condition)
+}
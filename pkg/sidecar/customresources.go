@@ -0,0 +1,252 @@
+package sidecar
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// customResourcesCache contains the configuration, which was merged with the
+// SidecarTemplates and SidecarInjectors and compiled. The version contains the
+// names and resource versions of the merged custom resources, so that the
+// configuration is only merged and compiled again, when one of the custom
+// resources was changed.
+type customResourcesCache struct {
+	mu      sync.Mutex
+	version string
+	config  *Config
+}
+
+// withCustomResources returns the compiled configuration, which contains the
+// given configuration and all valid SidecarTemplates and SidecarInjectors. The
+// merged configuration is cached in the given configuration, which must be
+// compiled. Invalid custom resources are not merged and are reported via the
+// logs, each time the configuration is merged again.
+func (c *Config) withCustomResources(templates []v1alpha1.SidecarTemplate, injectors []v1alpha1.SidecarInjector) (*Config, error) {
+	version := customResourcesVersion(templates, injectors)
+
+	c.customResources.mu.Lock()
+	defer c.customResources.mu.Unlock()
+
+	if c.customResources.config != nil && c.customResources.version == version {
+		return c.customResources.config, nil
+	}
+
+	merged, templateErrs, injectorErrs := mergeCustomResources(c, templates, injectors)
+	for name, err := range templateErrs {
+		log.Error(err, "Ignoring invalid SidecarTemplate.", "name", name)
+	}
+	for name, err := range injectorErrs {
+		log.Error(err, "Ignoring invalid SidecarInjector.", "name", name)
+	}
+
+	// All custom resources in the merged configuration were validated, so
+	// that the compilation can only fail because of a bug.
+	if err := merged.compile(); err != nil {
+		return nil, err
+	}
+
+	c.customResources.version = version
+	c.customResources.config = merged
+	return merged, nil
+}
+
+// customResourcesVersion returns the version of the given SidecarTemplates
+// and SidecarInjectors. The version changes, when a custom resource is
+// created, changed or deleted.
+func customResourcesVersion(templates []v1alpha1.SidecarTemplate, injectors []v1alpha1.SidecarInjector) string {
+	versions := make([]string, 0, len(templates)+len(injectors))
+	for _, template := range templates {
+		versions = append(versions, fmt.Sprintf("SidecarTemplate/%s/%s", template.Name, template.ResourceVersion))
+	}
+	for _, injector := range injectors {
+		versions = append(versions, fmt.Sprintf("SidecarInjector/%s/%s", injector.Name, injector.ResourceVersion))
+	}
+
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+// mergeCustomResources returns a new configuration, which contains the
+// injectors, containers and volumes from the given configuration and from all
+// valid SidecarTemplates and SidecarInjectors. The returned maps contain the
+// validation error for each invalid SidecarTemplate and SidecarInjector by the
+// name of the resource.
+func mergeCustomResources(cfg *Config, templates []v1alpha1.SidecarTemplate, injectors []v1alpha1.SidecarInjector) (*Config, map[string]error, map[string]error) {
+	merged := &Config{
+		Injectors:            append([]InjectorData{}, cfg.Injectors...),
+		Containers:           append([]Container{}, cfg.Containers...),
+		Volumes:              append([]Volume{}, cfg.Volumes...),
+		EnvironmentVariables: cfg.EnvironmentVariables,
+		OnConflict:           cfg.OnConflict,
+		ProtectAnnotations:   cfg.ProtectAnnotations,
+		Strict:               cfg.Strict,
+	}
+	templateErrs := make(map[string]error)
+	injectorErrs := make(map[string]error)
+
+	// The templates are sorted by their name, so that a name conflict between
+	// two templates always marks the same template as invalid.
+	sort.Slice(templates, func(a, b int) bool {
+		return templates[a].Name < templates[b].Name
+	})
+
+	containerNames := make(map[string]bool)
+	for _, container := range merged.Containers {
+		containerNames[container.Name] = true
+	}
+	volumeNames := make(map[string]bool)
+	for _, volume := range merged.Volumes {
+		volumeNames[volume.Name] = true
+	}
+
+	validTemplates := make(map[string]*v1alpha1.SidecarTemplate)
+	for i := range templates {
+		template := &templates[i]
+		if err := validateTemplate(template, containerNames, volumeNames); err != nil {
+			templateErrs[template.Name] = err
+			continue
+		}
+
+		for _, container := range append(append([]corev1.Container{}, template.Spec.InitContainers...), template.Spec.Containers...) {
+			containerNames[container.Name] = true
+			merged.Containers = append(merged.Containers, Container{Container: container, OnConflict: ConflictStrategy(template.Spec.OnConflict), Templates: template.Spec.Templates})
+		}
+		for _, volume := range template.Spec.Volumes {
+			volumeNames[volume.Name] = true
+			merged.Volumes = append(merged.Volumes, Volume{Volume: volume, OnConflict: ConflictStrategy(template.Spec.OnConflict), Templates: template.Spec.Templates})
+		}
+		validTemplates[template.Name] = template
+	}
+
+	sort.Slice(injectors, func(a, b int) bool {
+		return injectors[a].Name < injectors[b].Name
+	})
+
+	for _, injector := range injectors {
+		if err := validateInjector(&injector, validTemplates, templateErrs); err != nil {
+			injectorErrs[injector.Name] = err
+			continue
+		}
+
+		data := InjectorData{
+			Name:              injector.Name,
+			Selector:          injector.Spec.Selector,
+			NamespaceSelector: injector.Spec.NamespaceSelector,
+			Priority:          injector.Spec.Priority,
+			Exclusive:         injector.Spec.Exclusive,
+			NativeSidecars:    injector.Spec.NativeSidecars,
+			Mandatory:         injector.Spec.Mandatory,
+		}
+		for _, templateName := range injector.Spec.Templates {
+			template := validTemplates[templateName]
+			for _, container := range template.Spec.InitContainers {
+				data.InitContainers = append(data.InitContainers, container.Name)
+			}
+			for _, container := range template.Spec.Containers {
+				data.Containers = append(data.Containers, container.Name)
+			}
+			for _, volume := range template.Spec.Volumes {
+				data.Volumes = append(data.Volumes, volume.Name)
+			}
+		}
+		merged.Injectors = append(merged.Injectors, data)
+	}
+
+	return merged, templateErrs, injectorErrs
+}
+
+// validateTemplate checks if all containers and volumes in the given
+// SidecarTemplate have a name and that the names are not already used by
+// another template or by the configuration file.
+func validateTemplate(template *v1alpha1.SidecarTemplate, containerNames, volumeNames map[string]bool) error {
+	if len(template.Spec.InitContainers) == 0 && len(template.Spec.Containers) == 0 && len(template.Spec.Volumes) == 0 {
+		return fmt.Errorf("template does not define any init containers, containers or volumes")
+	}
+	if errs := validateConflictStrategy(ConflictStrategy(template.Spec.OnConflict), field.NewPath("spec", "onConflict")); len(errs) > 0 {
+		return errs.ToAggregate()
+	}
+
+	names := make(map[string]bool)
+	for _, container := range append(append([]corev1.Container{}, template.Spec.InitContainers...), template.Spec.Containers...) {
+		if container.Name == "" {
+			return fmt.Errorf("container name is required")
+		}
+		if container.Image == "" {
+			return fmt.Errorf("container %q: image is required", container.Name)
+		}
+		if container.RestartPolicy != nil && *container.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+			return fmt.Errorf("container %q: restart policy %q is not supported", container.Name, *container.RestartPolicy)
+		}
+		if names[container.Name] || containerNames[container.Name] {
+			return fmt.Errorf("container %q is already defined", container.Name)
+		}
+		names[container.Name] = true
+	}
+
+	names = make(map[string]bool)
+	for _, volume := range template.Spec.Volumes {
+		if volume.Name == "" {
+			return fmt.Errorf("volume name is required")
+		}
+		if names[volume.Name] || volumeNames[volume.Name] {
+			return fmt.Errorf("volume %q is already defined", volume.Name)
+		}
+		names[volume.Name] = true
+	}
+
+	if template.Spec.Templates {
+		templates := make(templateCache)
+		var allErrs field.ErrorList
+		for index := range template.Spec.InitContainers {
+			allErrs = append(allErrs, templates.add(&template.Spec.InitContainers[index], field.NewPath("spec", "initContainers").Index(index))...)
+		}
+		for index := range template.Spec.Containers {
+			allErrs = append(allErrs, templates.add(&template.Spec.Containers[index], field.NewPath("spec", "containers").Index(index))...)
+		}
+		for index := range template.Spec.Volumes {
+			allErrs = append(allErrs, templates.add(&template.Spec.Volumes[index], field.NewPath("spec", "volumes").Index(index))...)
+		}
+		if len(allErrs) > 0 {
+			return allErrs.ToAggregate()
+		}
+	}
+
+	return nil
+}
+
+// validateInjector checks if the selector of the given SidecarInjector is
+// valid and that all referenced SidecarTemplates exist and are valid.
+func validateInjector(injector *v1alpha1.SidecarInjector, validTemplates map[string]*v1alpha1.SidecarTemplate, templateErrs map[string]error) error {
+	if _, err := metav1.LabelSelectorAsSelector(&injector.Spec.Selector); err != nil {
+		return fmt.Errorf("invalid selector: %w", err)
+	}
+	if injector.Spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(injector.Spec.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespace selector: %w", err)
+		}
+	}
+
+	if len(injector.Spec.Templates) == 0 {
+		return fmt.Errorf("injector does not reference any templates")
+	}
+
+	for _, templateName := range injector.Spec.Templates {
+		if _, ok := validTemplates[templateName]; ok {
+			continue
+		}
+		if err, ok := templateErrs[templateName]; ok {
+			return fmt.Errorf("template %q is invalid: %w", templateName, err)
+		}
+		return fmt.Errorf("template %q not found", templateName)
+	}
+
+	return nil
+}
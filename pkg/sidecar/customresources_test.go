@@ -0,0 +1,227 @@
+package sidecar
+
+import (
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Custom Resources", func() {
+	Context("Merging custom resources into the configuration", func() {
+		It("Should add valid templates and injectors and report invalid ones", func() {
+			cfg := &Config{
+				Containers: []Container{{Container: corev1.Container{Name: "file-container", Image: "file-image"}}},
+			}
+
+			templates := []v1alpha1.SidecarTemplate{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid"},
+					Spec: v1alpha1.SidecarTemplateSpec{
+						InitContainers: []corev1.Container{{Name: "cr-initcontainer", Image: "cr-image"}},
+						Containers:     []corev1.Container{{Name: "cr-container", Image: "cr-image"}},
+						Volumes:        []corev1.Volume{{Name: "cr-volume"}},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "conflict"},
+					Spec: v1alpha1.SidecarTemplateSpec{
+						Containers: []corev1.Container{{Name: "file-container", Image: "cr-image"}},
+					},
+				},
+			}
+
+			injectors := []v1alpha1.SidecarInjector{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "valid"},
+					Spec: v1alpha1.SidecarInjectorSpec{
+						Selector:  metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+						Templates: []string{"valid"},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-template"},
+					Spec: v1alpha1.SidecarInjectorSpec{
+						Templates: []string{"conflict"},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "missing-template"},
+					Spec: v1alpha1.SidecarInjectorSpec{
+						Templates: []string{"missing"},
+					},
+				},
+			}
+
+			merged, templateErrs, injectorErrs := mergeCustomResources(cfg, templates, injectors)
+
+			Expect(len(cfg.Containers)).To(Equal(1))
+			Expect(len(merged.Containers)).To(Equal(3))
+			Expect(len(merged.Volumes)).To(Equal(1))
+			Expect(len(merged.Injectors)).To(Equal(1))
+			Expect(merged.Injectors[0].InitContainers).To(Equal([]string{"cr-initcontainer"}))
+			Expect(merged.Injectors[0].Containers).To(Equal([]string{"cr-container"}))
+			Expect(merged.Injectors[0].Volumes).To(Equal([]string{"cr-volume"}))
+
+			Expect(templateErrs).To(HaveKey("conflict"))
+			Expect(templateErrs).NotTo(HaveKey("valid"))
+			Expect(injectorErrs).To(HaveKey("invalid-template"))
+			Expect(injectorErrs).To(HaveKey("missing-template"))
+			Expect(injectorErrs).NotTo(HaveKey("valid"))
+		})
+	})
+
+	Context("Caching the merged configuration", func() {
+		It("Should only merge the configuration again when a custom resource is changed", func() {
+			crScheme := runtime.NewScheme()
+			Expect(v1alpha1.AddToScheme(crScheme)).To(Succeed())
+
+			template := &v1alpha1.SidecarTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "cache-template"},
+				Spec: v1alpha1.SidecarTemplateSpec{
+					Containers: []corev1.Container{{Name: "cache-container", Image: "cache-image:1"}},
+				},
+			}
+			c := fake.NewClientBuilder().WithScheme(crScheme).WithObjects(
+				template,
+				&v1alpha1.SidecarInjector{
+					ObjectMeta: metav1.ObjectMeta{Name: "cache-injector"},
+					Spec: v1alpha1.SidecarInjectorSpec{
+						Selector:  metav1.LabelSelector{MatchLabels: map[string]string{"app": "cache"}},
+						Templates: []string{"cache-template"},
+					},
+				},
+				&v1alpha1.SidecarInjector{
+					ObjectMeta: metav1.ObjectMeta{Name: "cache-injector-invalid"},
+					Spec: v1alpha1.SidecarInjectorSpec{
+						Templates: []string{"cache-template-missing"},
+					},
+				},
+			).Build()
+
+			cfg := &Config{}
+			Expect(cfg.compile()).To(Succeed())
+			injector := &Injector{Client: c, Config: cfg, CustomResources: true}
+
+			By("Merging the valid custom resources")
+			merged, err := injector.getConfig(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(merged.Injectors).To(HaveLen(1))
+			Expect(merged.Injectors[0].Name).To(Equal("cache-injector"))
+			Expect(merged.index).NotTo(BeNil())
+
+			By("Using the cached configuration when nothing was changed")
+			cached, err := injector.getConfig(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached).To(BeIdenticalTo(merged))
+
+			By("Merging the configuration again when a custom resource was changed")
+			Expect(c.Get(ctx, types.NamespacedName{Name: "cache-template"}, template)).To(Succeed())
+			template.Spec.Containers[0].Image = "cache-image:2"
+			Expect(c.Update(ctx, template)).To(Succeed())
+
+			changed, err := injector.getConfig(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed).NotTo(BeIdenticalTo(merged))
+			Expect(changed.Containers[0].Image).To(Equal("cache-image:2"))
+		})
+	})
+
+	Context("Creating Pods", func() {
+		It("Should inject sidecar from SidecarInjector and SidecarTemplate", func() {
+			By("Create SidecarTemplate and SidecarInjector")
+			err := k8sClient.Create(ctx, &v1alpha1.SidecarTemplate{
+				ObjectMeta: metav1.ObjectMeta{Name: "cr-template"},
+				Spec: v1alpha1.SidecarTemplateSpec{
+					Containers: []corev1.Container{{Name: "cr-container", Image: "cr-image"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			err = k8sClient.Create(ctx, &v1alpha1.SidecarInjector{
+				ObjectMeta: metav1.ObjectMeta{Name: "cr-injector"},
+				Spec: v1alpha1.SidecarInjectorSpec{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							"sidecar-injector": "cr-injector-test",
+						},
+					},
+					Templates: []string{"cr-template"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() bool {
+				injector := &v1alpha1.SidecarInjector{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "cr-injector"}, injector); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionTrue(injector.Status.Conditions, v1alpha1.ConditionTypeValid)
+			}).Should(BeTrue())
+
+			By("Create Pod")
+			err = k8sClient.Create(ctx, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-pod-cr-1",
+					Namespace: "default",
+					Labels: map[string]string{
+						"sidecar-injector": "cr-injector-test",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "my-container", Image: "my-image"}},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			pod := &corev1.Pod{}
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: "test-pod-cr-1", Namespace: "default"}, pod)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pod.Annotations[annotationStatusKey]).To(Equal("injected"))
+			Expect(len(pod.Spec.Containers)).To(Equal(2))
+			Expect(pod.Spec.Containers[1].Name).To(Equal("cr-container"))
+
+			By("Check status of SidecarInjector and SidecarTemplate")
+			Eventually(func() int32 {
+				injector := &v1alpha1.SidecarInjector{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "cr-injector"}, injector); err != nil {
+					return 0
+				}
+				return injector.Status.MatchedPods
+			}).Should(Equal(int32(1)))
+
+			Eventually(func() int32 {
+				template := &v1alpha1.SidecarTemplate{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "cr-template"}, template); err != nil {
+					return 0
+				}
+				return template.Status.MatchedPods
+			}).Should(Equal(int32(1)))
+		})
+
+		It("Should report invalid SidecarInjector", func() {
+			err := k8sClient.Create(ctx, &v1alpha1.SidecarInjector{
+				ObjectMeta: metav1.ObjectMeta{Name: "cr-injector-invalid"},
+				Spec: v1alpha1.SidecarInjectorSpec{
+					Templates: []string{"cr-template-missing"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Eventually(func() bool {
+				injector := &v1alpha1.SidecarInjector{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "cr-injector-invalid"}, injector); err != nil {
+					return false
+				}
+				return meta.IsStatusConditionFalse(injector.Status.Conditions, v1alpha1.ConditionTypeValid)
+			}).Should(BeTrue())
+		})
+	})
+})
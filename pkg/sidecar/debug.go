@@ -0,0 +1,72 @@
+package sidecar
+
+import (
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AuthenticatedHandler wraps the given handler, so that only requests with a
+// valid bearer token are handled. The token is verified via a TokenReview and
+// the user must be allowed to use the HTTP method on the path of the request,
+// which is checked via a SubjectAccessReview for the non-resource URL, e.g.
+//
+//	rules:
+//	  - nonResourceURLs: ["/debug/explain"]
+//	    verbs: ["post"]
+func AuthenticatedHandler(c client.Client, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+
+		tokenReview := &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}
+		if err := c.Create(r.Context(), tokenReview); err != nil {
+			log.Error(err, "Failed to create token review.")
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		if !tokenReview.Status.Authenticated {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+
+		user := tokenReview.Status.User
+		extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for key, value := range user.Extra {
+			extra[key] = authorizationv1.ExtraValue(value)
+		}
+
+		subjectAccessReview := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user.Username,
+				UID:    user.UID,
+				Groups: user.Groups,
+				Extra:  extra,
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+					Path: r.URL.Path,
+					Verb: strings.ToLower(r.Method),
+				},
+			},
+		}
+		if err := c.Create(r.Context(), subjectAccessReview); err != nil {
+			log.Error(err, "Failed to create subject access review.", "user", user.Username)
+			http.Error(w, "Forbidden.", http.StatusForbidden)
+			return
+		}
+		if !subjectAccessReview.Status.Allowed {
+			log.Info("Forbidden request to debug endpoint.", "user", user.Username, "path", r.URL.Path)
+			http.Error(w, "Forbidden.", http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,27 @@
+package sidecar
+
+import (
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+)
+
+// nativeSidecarsMinVersion is the first Kubernetes version, where native
+// sidecar containers are enabled by default.
+var nativeSidecarsMinVersion = version.MajorMinor(1, 29)
+
+// SupportsNativeSidecars checks via the discovery API if the Kubernetes API
+// server supports native sidecar containers, which are init containers with
+// the restart policy "Always".
+func SupportsNativeSidecars(client discovery.ServerVersionInterface) (bool, error) {
+	info, err := client.ServerVersion()
+	if err != nil {
+		return false, err
+	}
+
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return false, err
+	}
+
+	return serverVersion.AtLeast(nativeSidecarsMinVersion), nil
+}
@@ -0,0 +1,141 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	eventReasonInjected          = "Injected"
+	eventReasonInjectionFailed   = "InjectionFailed"
+	eventReasonInvalidAnnotation = "InvalidAnnotation"
+
+	eventActionInject = "Inject"
+
+	// eventInterval is the interval in which the same Event is only emitted
+	// once for a workload, so that a crashlooping workload doesn't flood the
+	// API server with Events.
+	eventInterval = 5 * time.Minute
+
+	// maxOwnerDepth is the maximum number of owner references, which are
+	// followed to find the workload of a Pod, e.g. Pod -> ReplicaSet ->
+	// Deployment.
+	maxOwnerDepth = 3
+)
+
+// EventRecorder emits Kubernetes Events for the outcome of an injection. The
+// Events are emitted for the workload which owns the Pod, because the Pod
+// doesn't exist yet, when the admission request is handled.
+type EventRecorder struct {
+	recorder events.EventRecorder
+	reader   client.Reader
+	emitted  *expirable.LRU[string, struct{}]
+}
+
+// NewEventRecorder returns a new EventRecorder. The reader is used to resolve
+// the owners of a Pod and should not be backed by a cache, so that we do not
+// have to watch all workloads in the cluster.
+func NewEventRecorder(recorder events.EventRecorder, reader client.Reader) *EventRecorder {
+	return &EventRecorder{
+		recorder: recorder,
+		reader:   reader,
+		emitted:  expirable.NewLRU[string, struct{}](4096, nil, eventInterval),
+	}
+}
+
+// Eventf emits an Event for the workload of the given Pod. If the same Event
+// was already emitted for the workload within the event interval, the Event
+// is dropped. The owners of the Pod are resolved in the background, so that
+// the admission request is not delayed.
+func (r *EventRecorder) Eventf(pod *corev1.Pod, namespace, eventtype, reason, note string, args ...any) {
+	if r == nil {
+		return
+	}
+
+	// The key is based on the direct owner of the Pod, because we do not want
+	// to resolve the owners for Events which are dropped.
+	owner := metav1.GetControllerOf(pod)
+	var ownerKey string
+	if owner != nil {
+		ownerKey = string(owner.UID)
+	} else if pod.Name != "" {
+		ownerKey = namespace + "/" + pod.Name
+	} else {
+		return
+	}
+
+	message := fmt.Sprintf(note, args...)
+	key := fmt.Sprintf("%s/%s/%s/%s", ownerKey, eventtype, reason, message)
+	if _, ok := r.emitted.Get(key); ok {
+		return
+	}
+	r.emitted.Add(key, struct{}{})
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		r.recorder.Eventf(getWorkload(ctx, r.reader, pod, namespace), nil, eventtype, reason, eventActionInject, "%s", message)
+	}()
+}
+
+// getWorkload returns the workload which owns the given Pod, by following the
+// controller references of the Pod and its owners. If an owner can not be
+// read, the last resolved owner is returned. If the Pod doesn't have an owner,
+// the Pod itself is returned.
+func getWorkload(ctx context.Context, reader client.Reader, pod *corev1.Pod, namespace string) *metav1.PartialObjectMetadata {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		workload := &metav1.PartialObjectMetadata{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: namespace,
+				UID:       pod.UID,
+			},
+		}
+		workload.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Pod"))
+		return workload
+	}
+
+	workload := ownerToObject(owner, namespace)
+	for depth := 1; depth < maxOwnerDepth; depth++ {
+		if err := reader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: workload.Name}, workload); err != nil {
+			log.V(1).Info("Failed to get owner.", "kind", workload.Kind, "name", workload.Name, "namespace", namespace, "error", err.Error())
+			break
+		}
+
+		owner = metav1.GetControllerOf(workload)
+		if owner == nil {
+			break
+		}
+		workload = ownerToObject(owner, namespace)
+	}
+
+	return workload
+}
+
+// ownerToObject returns an object with the metadata of the given owner
+// reference, which can be used as regarding object of an Event or to get the
+// metadata of the owner.
+func ownerToObject(owner *metav1.OwnerReference, namespace string) *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      owner.Name,
+			Namespace: namespace,
+			UID:       owner.UID,
+		},
+	}
+	obj.SetGroupVersionKind(schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind))
+
+	return obj
+}
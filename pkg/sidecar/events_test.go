@@ -0,0 +1,91 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Events", func() {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "deployment-uid"},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-12345",
+			Namespace: "default",
+			UID:       "replicaset-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "deployment-uid", Controller: ptr.To(true)},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "app-12345-",
+			Namespace:    "default",
+			Labels:       map[string]string{"app": "events"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-12345", UID: "replicaset-uid", Controller: ptr.To(true)},
+			},
+		},
+	}
+
+	Context("Resolving the workload of a Pod", func() {
+		It("Should follow the owner references of the Pod", func() {
+			workload := getWorkload(ctx, fake.NewClientBuilder().WithObjects(deployment, replicaSet).Build(), pod, "default")
+			Expect(workload.Kind).To(Equal("Deployment"))
+			Expect(workload.Name).To(Equal("app"))
+			Expect(workload.UID).To(BeEquivalentTo("deployment-uid"))
+		})
+
+		It("Should return the last resolved owner when an owner can not be read", func() {
+			workload := getWorkload(ctx, fake.NewClientBuilder().Build(), pod, "default")
+			Expect(workload.Kind).To(Equal("ReplicaSet"))
+			Expect(workload.Name).To(Equal("app-12345"))
+		})
+	})
+
+	Context("Emitting Events", func() {
+		It("Should emit rate limited Events for injections and failures", func() {
+			fakeRecorder := events.NewFakeRecorder(10)
+			injector := &Injector{
+				Config: &Config{
+					Injectors: []InjectorData{
+						{
+							Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "events"}},
+							Containers: []string{"events-container"},
+						},
+					},
+					Containers: []Container{
+						{Container: corev1.Container{Name: "events-container", Image: "events-image"}},
+					},
+				},
+				Decoder: admission.NewDecoder(scheme.Scheme),
+				Events:  NewEventRecorder(fakeRecorder, fake.NewClientBuilder().WithObjects(deployment, replicaSet).Build()),
+			}
+
+			_, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			_, res = handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Eventually(fakeRecorder.Events).Should(Receive(Equal(`Normal Injected Injected container "events-container"`)))
+
+			failingPod := pod.DeepCopy()
+			failingPod.Spec.Containers = []corev1.Container{{Name: "events-container", Image: "app"}}
+			_, res = handle(injector, admissionv1.Create, failingPod)
+			Expect(res.Allowed).To(BeFalse())
+			Eventually(fakeRecorder.Events).Should(Receive(Equal(`Warning InjectionFailed Injection failed: container "events-container" can not be injected, because the Pod already contains a container with the same name`)))
+
+			Consistently(fakeRecorder.Events).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,455 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// Explanation describes why resources are or are not injected into a Pod. It
+// contains the decision for each injector, the annotations of the Pod which
+// were read, the environment variables and resource overrides which were
+// applied, the volume parameters which were set and the resulting patch.
+type Explanation struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+
+	Injectors   []InjectorExplanation `json:"injectors"`
+	Annotations map[string]string     `json:"annotations,omitempty"`
+
+	InitContainers       []string                         `json:"initContainers,omitempty"`
+	Containers           []string                         `json:"containers,omitempty"`
+	Volumes              []string                         `json:"volumes,omitempty"`
+	EnvironmentVariables []EnvironmentVariableExplanation `json:"environmentVariables,omitempty"`
+	ResourceOverrides    []ResourceOverrideExplanation    `json:"resourceOverrides,omitempty"`
+	VolumeParameters     []VolumeParameterExplanation     `json:"volumeParameters,omitempty"`
+
+	Outcome  string                         `json:"outcome"`
+	Reason   string                         `json:"reason"`
+	Message  string                         `json:"message,omitempty"`
+	Warnings []string                       `json:"warnings,omitempty"`
+	Patch    []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+}
+
+// InjectorExplanation describes if an injector matched the Pod and why.
+type InjectorExplanation struct {
+	Name              string `json:"name"`
+	Selector          string `json:"selector"`
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	Matched           bool   `json:"matched"`
+	Applied           bool   `json:"applied"`
+	Reason            string `json:"reason"`
+}
+
+// EnvironmentVariableExplanation describes if an environment variable was
+// added to an injected container.
+type EnvironmentVariableExplanation struct {
+	Container  string `json:"container"`
+	Name       string `json:"name"`
+	Source     string `json:"source"`
+	Annotation string `json:"annotation,omitempty"`
+	Applied    bool   `json:"applied"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ResourceOverrideExplanation describes an annotation, which overwrites the
+// resources of an injected container.
+type ResourceOverrideExplanation struct {
+	Container  string `json:"container"`
+	Annotation string `json:"annotation"`
+	Value      string `json:"value"`
+	Error      string `json:"error,omitempty"`
+}
+
+// VolumeParameterExplanation describes an annotation, which sets a field of an
+// injected volume.
+type VolumeParameterExplanation struct {
+	Volume     string `json:"volume"`
+	Field      string `json:"field"`
+	Annotation string `json:"annotation"`
+	Value      string `json:"value"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Explain returns an explanation for the injection of resources into the
+// given Pod. The same logic as for admission requests is used, but no metrics
+// are recorded and no Events are emitted.
+func (i *Injector) Explain(ctx context.Context, pod *corev1.Pod) (*Explanation, error) {
+	if pod.Namespace == "" {
+		pod.Namespace = "default"
+	}
+
+	cfg, err := i.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &Explanation{Name: pod.Name, Namespace: pod.Namespace}
+	if explanation.Name == "" {
+		explanation.Name = pod.GenerateName
+	}
+
+	for key, value := range pod.Annotations {
+		if key == annotationInjectKey || strings.HasPrefix(key, annotationInjectKey+"/") {
+			if explanation.Annotations == nil {
+				explanation.Annotations = make(map[string]string)
+			}
+			explanation.Annotations[key] = value
+		}
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	res, reason, err := i.getResourcesToInject(ctx, req, pod.DeepCopy(), cfg)
+	if err != nil {
+		explanation.Outcome = outcomeErrored
+		explanation.Message = err.Error()
+	}
+
+	if err := i.explainInjectors(ctx, explanation, pod, cfg, res, reason, err); err != nil {
+		return nil, err
+	}
+
+	if res != nil {
+		explanation.InitContainers = res.initContainers
+		explanation.Containers = res.containers
+		explanation.Volumes = res.volumes
+		if err := explainOverrides(explanation, pod, cfg, res); err != nil {
+			return nil, err
+		}
+	}
+
+	// The resources are injected into a copy of the Pod by a copy of the
+	// injector, which doesn't record any metrics and doesn't emit Events.
+	if explanation.Outcome != outcomeErrored {
+		injector := *i
+		injector.Events = nil
+		injector.dryRun = true
+
+		response, outcome, reason := injector.mutate(ctx, req, pod.DeepCopy())
+		explanation.Outcome = outcome
+		explanation.Reason = reason
+		explanation.Warnings = response.Warnings
+		explanation.Patch = response.Patches
+		if response.Result != nil {
+			explanation.Message = response.Result.Message
+		}
+	}
+
+	return explanation, nil
+}
+
+// explainInjectors adds the decision for each injector of the configuration
+// to the explanation. The resources, the reason and the error are the results
+// of getResourcesToInject, which are used to check if a matched injector was
+// applied.
+func (i *Injector) explainInjectors(ctx context.Context, explanation *Explanation, pod *corev1.Pod, cfg *Config, res *resources, reason string, resErr error) error {
+	var namespaceLabels labels.Set
+
+	idx, err := cfg.getIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, compiled := range idx.injectors {
+		injector := compiled.injector
+		injectorExplanation := InjectorExplanation{
+			Name:     compiled.name,
+			Selector: metav1.FormatLabelSelector(&injector.Selector),
+		}
+		if injector.NamespaceSelector != nil {
+			injectorExplanation.NamespaceSelector = metav1.FormatLabelSelector(injector.NamespaceSelector)
+		}
+
+		switch {
+		case !compiled.selector.Matches(labels.Set(pod.Labels)):
+			injectorExplanation.Reason = "labels of the Pod do not match the selector"
+
+		case compiled.namespaceSelector != nil:
+			if namespaceLabels == nil {
+				namespaceLabels, err = i.getNamespaceLabels(ctx, pod.Namespace)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !compiled.namespaceSelector.Matches(namespaceLabels) {
+				injectorExplanation.Reason = "labels of the Namespace do not match the namespace selector"
+			} else {
+				injectorExplanation.Matched = true
+			}
+
+		default:
+			injectorExplanation.Matched = true
+		}
+
+		if injectorExplanation.Matched {
+			switch {
+			case res != nil && slices.Contains(res.injectors, injectorExplanation.Name):
+				injectorExplanation.Applied = true
+				injectorExplanation.Reason = "matched"
+			case resErr != nil:
+				injectorExplanation.Reason = "matched, but the injection failed"
+			case reason == reasonAlreadyInjected:
+				injectorExplanation.Reason = "matched, but the Pod is already injected"
+			case reason == reasonDisabled:
+				injectorExplanation.Reason = fmt.Sprintf("matched, but the injection is disabled via the %q annotation", annotationInjectKey)
+			case reason == reasonExcluded:
+				injectorExplanation.Reason = fmt.Sprintf("matched, but all containers are excluded via the %q annotation", annotationExcludeContainersKey)
+			case injector.Exclusive:
+				injectorExplanation.Reason = "matched, but an exclusive injector with a higher priority was applied"
+			default:
+				injectorExplanation.Reason = "matched, but an exclusive injector was applied"
+			}
+		}
+
+		explanation.Injectors = append(explanation.Injectors, injectorExplanation)
+	}
+
+	return nil
+}
+
+// explainOverrides adds the environment variables and resource overrides for
+// the injected containers and the parameters for the injected volumes to the
+// explanation.
+func explainOverrides(explanation *Explanation, pod *corev1.Pod, cfg *Config, res *resources) error {
+	idx, err := cfg.getIndex()
+	if err != nil {
+		return err
+	}
+
+	data := newTemplateData(pod, explanation.Namespace)
+	for _, name := range append(slices.Clone(res.initContainers), res.containers...) {
+		for _, envVar := range cfg.EnvironmentVariables {
+			if !envVar.targets(name) {
+				continue
+			}
+
+			envVarExplanation := EnvironmentVariableExplanation{
+				Container:  name,
+				Name:       envVar.Name,
+				Source:     envVar.source(),
+				Annotation: envVar.Annotation,
+			}
+			env, err := envVar.envVar(data, field.NewPath("environmentVariables").Key(envVar.Name), idx.templates)
+			if err != nil {
+				envVarExplanation.Error = err.Error()
+			}
+			envVarExplanation.Applied = env != nil
+			explanation.EnvironmentVariables = append(explanation.EnvironmentVariables, envVarExplanation)
+		}
+	}
+
+	for annotationKey, names := range map[string][]string{annotationInitContainersKey: res.initContainers, annotationContainersKey: res.containers} {
+		for _, name := range names {
+			annotation := fmt.Sprintf("%s-%s-%s", annotationKey, name, resourcesAnnotationSuffix)
+			if val, ok := pod.Annotations[annotation]; ok && val != "" {
+				override := ResourceOverrideExplanation{Container: name, Annotation: annotation, Value: val}
+				if _, err := parseResourceOverrides(val); err != nil {
+					override.Error = err.Error()
+				}
+				explanation.ResourceOverrides = append(explanation.ResourceOverrides, override)
+			}
+
+			for _, resourceAnnotation := range resourceAnnotations {
+				annotation := fmt.Sprintf("%s-%s-%s", annotationKey, name, resourceAnnotation.suffix)
+				val, ok := pod.Annotations[annotation]
+				if !ok || val == "" {
+					continue
+				}
+
+				override := ResourceOverrideExplanation{Container: name, Annotation: annotation, Value: val}
+				if _, err := parseResourceValue(val); err != nil {
+					override.Error = err.Error()
+				}
+				explanation.ResourceOverrides = append(explanation.ResourceOverrides, override)
+			}
+		}
+	}
+
+	slices.SortFunc(explanation.ResourceOverrides, func(a, b ResourceOverrideExplanation) int {
+		return strings.Compare(a.Annotation, b.Annotation)
+	})
+
+	for _, volume := range cfg.Volumes {
+		if !slices.Contains(res.volumes, volume.Name) {
+			continue
+		}
+
+		for _, parameter := range idx.volumeParameters[volume.Name] {
+			val, ok := pod.Annotations[parameter.Annotation]
+			if !ok || val == "" {
+				continue
+			}
+
+			parameterExplanation := VolumeParameterExplanation{Volume: volume.Name, Field: string(parameter.Field), Annotation: parameter.Annotation, Value: val}
+			if _, invalid := setVolumeParameters(*volume.Volume.DeepCopy(), []compiledVolumeParameter{parameter}, pod.Annotations); len(invalid) > 0 {
+				parameterExplanation.Error = invalid[0]
+			}
+			explanation.VolumeParameters = append(explanation.VolumeParameters, parameterExplanation)
+		}
+	}
+
+	return nil
+}
+
+// Write writes a human readable representation of the explanation to the
+// given writer.
+func (e *Explanation) Write(w io.Writer) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Pod: %s/%s\n", e.Namespace, e.Name)
+	fmt.Fprintf(&b, "Outcome: %s", e.Outcome)
+	if e.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", e.Reason)
+	}
+	b.WriteString("\n")
+	if e.Message != "" {
+		fmt.Fprintf(&b, "Message: %s\n", e.Message)
+	}
+
+	b.WriteString("\nInjectors:\n")
+	if len(e.Injectors) == 0 {
+		b.WriteString("  <none>\n")
+	}
+	for _, injector := range e.Injectors {
+		fmt.Fprintf(&b, "  - %s: %s\n", injector.Name, injector.Reason)
+		fmt.Fprintf(&b, "      selector: %s\n", injector.Selector)
+		if injector.NamespaceSelector != "" {
+			fmt.Fprintf(&b, "      namespaceSelector: %s\n", injector.NamespaceSelector)
+		}
+	}
+
+	b.WriteString("\nAnnotations:\n")
+	if len(e.Annotations) == 0 {
+		b.WriteString("  <none>\n")
+	}
+	for _, key := range sortedKeys(e.Annotations) {
+		fmt.Fprintf(&b, "  %s: %s\n", key, e.Annotations[key])
+	}
+
+	b.WriteString("\nResources:\n")
+	fmt.Fprintf(&b, "  initContainers: %s\n", formatList(e.InitContainers))
+	fmt.Fprintf(&b, "  containers: %s\n", formatList(e.Containers))
+	fmt.Fprintf(&b, "  volumes: %s\n", formatList(e.Volumes))
+
+	if len(e.EnvironmentVariables) > 0 {
+		b.WriteString("\nEnvironment Variables:\n")
+		for _, envVar := range e.EnvironmentVariables {
+			status := "applied"
+			switch {
+			case envVar.Error != "":
+				status = "not applied, " + envVar.Error
+			case !envVar.Applied:
+				status = fmt.Sprintf("not applied, %s is missing", strings.Fields(envVar.Source)[0])
+			}
+			fmt.Fprintf(&b, "  - %s.%s from %s: %s\n", envVar.Container, envVar.Name, envVar.Source, status)
+		}
+	}
+
+	if len(e.ResourceOverrides) > 0 {
+		b.WriteString("\nResource Overrides:\n")
+		for _, override := range e.ResourceOverrides {
+			status := "applied"
+			if override.Error != "" {
+				status = "invalid, " + override.Error
+			}
+			fmt.Fprintf(&b, "  - %s=%s: %s\n", override.Annotation, override.Value, status)
+		}
+	}
+
+	if len(e.VolumeParameters) > 0 {
+		b.WriteString("\nVolume Parameters:\n")
+		for _, parameter := range e.VolumeParameters {
+			status := "applied"
+			if parameter.Error != "" {
+				status = "invalid, " + parameter.Error
+			}
+			fmt.Fprintf(&b, "  - %s.%s=%s from %s: %s\n", parameter.Volume, parameter.Field, parameter.Value, parameter.Annotation, status)
+		}
+	}
+
+	if len(e.Warnings) > 0 {
+		b.WriteString("\nWarnings:\n")
+		for _, warning := range e.Warnings {
+			fmt.Fprintf(&b, "  - %s\n", warning)
+		}
+	}
+
+	if len(e.Patch) > 0 {
+		b.WriteString("\nPatch:\n")
+		for _, operation := range e.Patch {
+			value, err := json.Marshal(operation.Value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "  %s %s %s\n", operation.Operation, operation.Path, value)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "<none>"
+	}
+	return strings.Join(values, ", ")
+}
+
+// ExplainHandler returns a HTTP handler, which returns the explanation for
+// the Pod in the body of a POST request as JSON. The Pod can be provided as
+// YAML or JSON. The handler doesn't authenticate the requests, so that it
+// must be wrapped with an authentication and authorization filter.
+func (i *Injector) ExplainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+
+		pod := &corev1.Pod{}
+		if err := utilyaml.NewYAMLOrJSONDecoder(http.MaxBytesReader(w, r.Body, 1<<20), 4096).Decode(pod); err != nil {
+			http.Error(w, fmt.Sprintf("Could not decode Pod: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+
+		explanation, err := i.Explain(r.Context(), pod)
+		if err != nil {
+			log.Error(err, "Failed to explain injection.", "name", pod.Name, "namespace", pod.Namespace)
+			http.Error(w, fmt.Sprintf("Could not explain injection: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(explanation); err != nil {
+			log.Error(err, "Failed to write explanation.", "name", pod.Name, "namespace", pod.Namespace)
+		}
+	})
+}
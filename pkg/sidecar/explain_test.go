@@ -0,0 +1,173 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Explain", func() {
+	injector := &Injector{
+		Config: &Config{
+			Injectors: []InjectorData{
+				{
+					Name:       "logging",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "explain"}},
+					Containers: []string{"log-shipper"},
+				},
+				{
+					Name:              "monitoring",
+					Selector:          metav1.LabelSelector{MatchLabels: map[string]string{"app": "explain"}},
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"monitoring": "true"}},
+					Containers:        []string{"exporter"},
+				},
+				{
+					Name:       "proxy",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}},
+					Containers: []string{"proxy"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper"}},
+				{Container: corev1.Container{Name: "exporter", Image: "exporter"}},
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+			},
+			EnvironmentVariables: []EnvironmentVariable{
+				{Name: "LOG_LEVEL", Container: "log-shipper", Annotation: "explain/log-level"},
+				{Name: "LOG_FORMAT", Container: "log-shipper", Annotation: "explain/log-format"},
+				{Name: "PROXY_PORT", Container: "proxy", Annotation: "explain/proxy-port"},
+			},
+		},
+		Client:  fake.NewClientBuilder().WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}).Build(),
+		Decoder: admission.NewDecoder(scheme.Scheme),
+	}
+
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "explain",
+				Labels: map[string]string{"app": "explain"},
+				Annotations: map[string]string{
+					"explain/log-level": "debug",
+					annotationContainersKey + "-log-shipper-cpulimits": "invalid",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "app"}},
+			},
+		}
+	}
+
+	Context("Explaining the injection", func() {
+		It("Should explain the decision for each injector", func() {
+			explanation, err := injector.Explain(ctx, newPod())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(explanation.Namespace).To(Equal("default"))
+			Expect(explanation.Outcome).To(Equal(outcomeInjected))
+			Expect(explanation.Reason).To(Equal(reasonInjectors))
+
+			Expect(explanation.Injectors).To(Equal([]InjectorExplanation{
+				{Name: "logging", Selector: "app=explain", Matched: true, Applied: true, Reason: "matched"},
+				{Name: "monitoring", Selector: "app=explain", NamespaceSelector: "monitoring=true", Reason: "labels of the Namespace do not match the namespace selector"},
+				{Name: "proxy", Selector: "app=other", Reason: "labels of the Pod do not match the selector"},
+			}))
+			Expect(explanation.Annotations).To(Equal(map[string]string{annotationContainersKey + "-log-shipper-cpulimits": "invalid"}))
+			Expect(explanation.Containers).To(Equal([]string{"log-shipper"}))
+		})
+
+		It("Should explain the environment variables and resource overrides", func() {
+			explanation, err := injector.Explain(ctx, newPod())
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(explanation.EnvironmentVariables).To(Equal([]EnvironmentVariableExplanation{
+				{Container: "log-shipper", Name: "LOG_LEVEL", Source: "annotation explain/log-level", Annotation: "explain/log-level", Applied: true},
+				{Container: "log-shipper", Name: "LOG_FORMAT", Source: "annotation explain/log-format", Annotation: "explain/log-format", Applied: false},
+			}))
+			Expect(explanation.ResourceOverrides).To(HaveLen(1))
+			Expect(explanation.ResourceOverrides[0].Annotation).To(Equal(annotationContainersKey + "-log-shipper-cpulimits"))
+			Expect(explanation.ResourceOverrides[0].Error).NotTo(BeEmpty())
+			Expect(explanation.Patch).NotTo(BeEmpty())
+
+			out := &bytes.Buffer{}
+			Expect(explanation.Write(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("  - monitoring: labels of the Namespace do not match the namespace selector\n"))
+			Expect(out.String()).To(ContainSubstring("  - log-shipper.LOG_FORMAT from annotation explain/log-format: not applied, annotation is missing\n"))
+		})
+
+		It("Should explain why nothing is injected", func() {
+			pod := newPod()
+			pod.Annotations[annotationInjectKey] = "disabled"
+
+			explanation, err := injector.Explain(ctx, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(explanation.Outcome).To(Equal(outcomeSkipped))
+			Expect(explanation.Reason).To(Equal(reasonDisabled))
+			Expect(explanation.Injectors[0].Applied).To(BeFalse())
+			Expect(explanation.Injectors[0].Reason).To(Equal(`matched, but the injection is disabled via the "sidecar-injector.ricoberger.de" annotation`))
+			Expect(explanation.Patch).To(BeEmpty())
+		})
+	})
+
+	Context("Serving the debug endpoint", func() {
+		reviewClient := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				switch review := obj.(type) {
+				case *authenticationv1.TokenReview:
+					review.Status.Authenticated = review.Spec.Token == "valid" || review.Spec.Token == "forbidden"
+					review.Status.User.Username = review.Spec.Token
+				case *authorizationv1.SubjectAccessReview:
+					review.Status.Allowed = review.Spec.User == "valid" && review.Spec.NonResourceAttributes.Path == "/debug/explain" && review.Spec.NonResourceAttributes.Verb == "post"
+				}
+				return nil
+			},
+		}).Build()
+		handler := AuthenticatedHandler(reviewClient, injector.ExplainHandler())
+
+		request := func(token string) *httptest.ResponseRecorder {
+			body, err := json.Marshal(newPod())
+			Expect(err).NotTo(HaveOccurred())
+
+			req := httptest.NewRequest(http.MethodPost, "/debug/explain", strings.NewReader(string(body)))
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		It("Should reject requests without a valid token", func() {
+			Expect(request("").Code).To(Equal(http.StatusUnauthorized))
+			Expect(request("invalid").Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should reject requests from users which are not allowed to use the endpoint", func() {
+			Expect(request("forbidden").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("Should return the explanation for allowed users", func() {
+			rec := request("valid")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			explanation := &Explanation{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), explanation)).To(Succeed())
+			Expect(explanation.Outcome).To(Equal(outcomeInjected))
+			Expect(explanation.Containers).To(Equal([]string{"log-shipper"}))
+		})
+	})
+})
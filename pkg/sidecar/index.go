@@ -0,0 +1,198 @@
+package sidecar
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// configIndex is compiled from a configuration, so that the label selectors
+// of the injectors are only parsed once and the containers, volumes and
+// environment variables can be looked up by their name. The index must not be
+// changed after it was compiled, because it is shared by all requests.
+type configIndex struct {
+	injectors            []compiledInjector
+	containers           map[string]*Container
+	volumes              map[string]*Volume
+	volumeParameters     map[string][]compiledVolumeParameter
+	environmentVariables map[string][]EnvironmentVariable
+	templates            templateCache
+}
+
+// compiledInjector is an injector of the configuration with the parsed label
+// selectors. If the injector doesn't have a namespace selector, the
+// namespaceSelector is nil.
+type compiledInjector struct {
+	name              string
+	injector          *InjectorData
+	selector          labels.Selector
+	namespaceSelector labels.Selector
+}
+
+// compiledVolumeParameter is a parameter of a volume with the compiled
+// pattern.
+type compiledVolumeParameter struct {
+	VolumeParameter
+	pattern *regexp.Regexp
+}
+
+// newConfigIndex compiles the index for the given configuration. It returns
+// an error, when the label selector of an injector is invalid.
+func newConfigIndex(c *Config) (*configIndex, error) {
+	idx := &configIndex{
+		injectors:            make([]compiledInjector, 0, len(c.Injectors)),
+		containers:           make(map[string]*Container, len(c.Containers)),
+		volumes:              make(map[string]*Volume, len(c.Volumes)),
+		volumeParameters:     make(map[string][]compiledVolumeParameter),
+		environmentVariables: make(map[string][]EnvironmentVariable),
+		templates:            make(templateCache),
+	}
+
+	for index := range c.Injectors {
+		injector := &c.Injectors[index]
+
+		selector, err := metav1.LabelSelectorAsSelector(&injector.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector of injector %q: %w", injector.name(index), err)
+		}
+
+		var namespaceSelector labels.Selector
+		if injector.NamespaceSelector != nil {
+			namespaceSelector, err = metav1.LabelSelectorAsSelector(injector.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace selector of injector %q: %w", injector.name(index), err)
+			}
+		}
+
+		idx.injectors = append(idx.injectors, compiledInjector{
+			name:              injector.name(index),
+			injector:          injector,
+			selector:          selector,
+			namespaceSelector: namespaceSelector,
+		})
+	}
+
+	// If a name is used multiple times, the first container or volume is used,
+	// like it was done by the linear search before the index was introduced.
+	for index := range c.Containers {
+		if _, ok := idx.containers[c.Containers[index].Name]; !ok {
+			idx.containers[c.Containers[index].Name] = &c.Containers[index]
+		}
+	}
+	for index := range c.Volumes {
+		if _, ok := idx.volumes[c.Volumes[index].Name]; !ok {
+			idx.volumes[c.Volumes[index].Name] = &c.Volumes[index]
+		}
+	}
+
+	// The patterns of the volume parameters are compiled once, so that they
+	// can be used for all requests.
+	for name, volume := range idx.volumes {
+		for _, parameter := range volume.Parameters {
+			pattern, err := parameter.compile()
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern of parameter %q of volume %q: %w", parameter.Annotation, name, err)
+			}
+			idx.volumeParameters[name] = append(idx.volumeParameters[name], compiledVolumeParameter{VolumeParameter: parameter, pattern: pattern})
+		}
+	}
+
+	// The environment variables are grouped by the names of the containers,
+	// so that an environment variable for multiple or all containers is
+	// contained in the list of each container.
+	for _, envVar := range c.EnvironmentVariables {
+		for name := range idx.containers {
+			if envVar.targets(name) {
+				idx.environmentVariables[name] = append(idx.environmentVariables[name], envVar)
+			}
+		}
+	}
+
+	// The templates are parsed with the paths, which are used when they are
+	// rendered for a Pod, so that they are only parsed once.
+	var templateErrs field.ErrorList
+	for _, container := range idx.containers {
+		if container.Templates {
+			templateErrs = append(templateErrs, idx.templates.add(&container.Container, field.NewPath("containers").Key(container.Name))...)
+		}
+	}
+	for _, volume := range idx.volumes {
+		if volume.Templates {
+			templateErrs = append(templateErrs, idx.templates.add(&volume.Volume, field.NewPath("volumes").Key(volume.Name))...)
+		}
+	}
+	for _, compiled := range idx.injectors {
+		for index := range compiled.injector.AppContainers {
+			if compiled.injector.AppContainers[index].Templates {
+				templateErrs = append(templateErrs, idx.templates.add(&compiled.injector.AppContainers[index], field.NewPath("injectors").Key(compiled.name).Child("appContainers").Index(index))...)
+			}
+		}
+		if compiled.injector.Pod != nil && compiled.injector.Pod.Templates {
+			templateErrs = append(templateErrs, idx.templates.add(compiled.injector.Pod, field.NewPath("injectors").Key(compiled.name).Child("pod"))...)
+		}
+	}
+	for _, envVar := range c.EnvironmentVariables {
+		if envVar.Template != "" {
+			templateErrs = append(templateErrs, idx.templates.addTemplate(envVar.Template, field.NewPath("environmentVariables").Key(envVar.Name).Child("template"))...)
+		}
+	}
+	if len(templateErrs) > 0 {
+		return nil, templateErrs.ToAggregate()
+	}
+
+	return idx, nil
+}
+
+// compile compiles the index of the configuration. It must be called before
+// the configuration is shared, e.g. after the configuration was loaded.
+func (c *Config) compile() error {
+	idx, err := newConfigIndex(c)
+	if err != nil {
+		return err
+	}
+
+	c.index = idx
+	c.customResources = &customResourcesCache{}
+	return nil
+}
+
+// getIndex returns the compiled index of the configuration. If the
+// configuration wasn't compiled, e.g. because it was created in the code, the
+// index is compiled for each call.
+func (c *Config) getIndex() (*configIndex, error) {
+	if c.index != nil {
+		return c.index, nil
+	}
+	return newConfigIndex(c)
+}
+
+// container returns a copy of the container with the given name, so that the
+// returned container can be changed.
+func (idx *configIndex) container(name string) (Container, error) {
+	container, ok := idx.containers[name]
+	if !ok {
+		return Container{}, fmt.Errorf("container not found")
+	}
+
+	copied := Container{Container: *container.Container.DeepCopy(), OnConflict: container.OnConflict, Templates: container.Templates}
+	if container.ResourceBounds != nil {
+		copied.ResourceBounds = &ResourceBounds{Min: container.ResourceBounds.Min.DeepCopy(), Max: container.ResourceBounds.Max.DeepCopy()}
+	}
+
+	return copied, nil
+}
+
+// volume returns a copy of the volume with the given name, so that the
+// returned volume can be changed.
+func (idx *configIndex) volume(name string) (Volume, error) {
+	volume, ok := idx.volumes[name]
+	if !ok {
+		return Volume{}, fmt.Errorf("volume not found")
+	}
+
+	return Volume{Volume: *volume.Volume.DeepCopy(), OnConflict: volume.OnConflict, Parameters: slices.Clone(volume.Parameters), Templates: volume.Templates}, nil
+}
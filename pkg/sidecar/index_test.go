@@ -0,0 +1,101 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var _ = Describe("Config index", func() {
+	It("Should compile the index when the configuration is loaded", func() {
+		cfg, err := parseConfig([]byte(`
+injectors:
+  - name: test
+    selector:
+      matchLabels:
+        app: test
+    namespaceSelector:
+      matchLabels:
+        team: test
+    containers: [test-container]
+    volumes: [test-volume]
+containers:
+  - name: test-container
+    image: test-image
+volumes:
+  - name: test-volume
+    emptyDir: {}
+environmentVariables:
+  - name: TEST
+    container: test-container
+    annotation: sidecar-injector.ricoberger.de/test
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.index).NotTo(BeNil())
+
+		Expect(cfg.index.injectors).To(HaveLen(1))
+		Expect(cfg.index.injectors[0].name).To(Equal("test"))
+		Expect(cfg.index.injectors[0].selector.Matches(labels.Set{"app": "test"})).To(BeTrue())
+		Expect(cfg.index.injectors[0].namespaceSelector.Matches(labels.Set{"team": "other"})).To(BeFalse())
+		Expect(cfg.index.containers).To(HaveKey("test-container"))
+		Expect(cfg.index.volumes).To(HaveKey("test-volume"))
+		Expect(cfg.index.environmentVariables["test-container"]).To(HaveLen(1))
+	})
+
+	It("Should fail for invalid selectors", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{Selector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Invalid"}}}},
+			},
+		}
+		Expect(cfg.compile()).To(MatchError(ContainSubstring(`invalid selector of injector "injectors[0]"`)))
+
+		cfg = &Config{
+			Injectors: []InjectorData{
+				{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Invalid"}}}},
+			},
+		}
+		Expect(cfg.compile()).To(MatchError(ContainSubstring(`invalid namespace selector of injector "injectors[0]"`)))
+	})
+
+	It("Should compile the patterns of the volume parameters", func() {
+		volume := Volume{
+			Volume:     corev1.Volume{Name: "test-volume", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "default"}}},
+			Parameters: []VolumeParameter{{Annotation: "example.com/secret", Field: VolumeParameterSecretName, Pattern: "app-.*"}},
+		}
+		cfg := &Config{Volumes: []Volume{volume}}
+		Expect(cfg.compile()).To(Succeed())
+		Expect(cfg.index.volumeParameters["test-volume"]).To(HaveLen(1))
+		Expect(cfg.index.volumeParameters["test-volume"][0].pattern.MatchString("app-secret")).To(BeTrue())
+		Expect(cfg.index.volumeParameters["test-volume"][0].pattern.MatchString("other-app-secret")).To(BeFalse())
+
+		volume.Parameters[0].Pattern = "app-("
+		cfg = &Config{Volumes: []Volume{volume}}
+		Expect(cfg.compile()).To(MatchError(ContainSubstring(`invalid pattern of parameter "example.com/secret" of volume "test-volume"`)))
+	})
+
+	It("Should return copies of the containers and volumes", func() {
+		cfg := &Config{
+			Containers: []Container{{Container: corev1.Container{Name: "test-container", Image: "test-image", Args: []string{"arg"}}}},
+			Volumes:    []Volume{{Volume: corev1.Volume{Name: "test-volume", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}},
+		}
+		Expect(cfg.compile()).To(Succeed())
+
+		container, err := cfg.index.container("test-container")
+		Expect(err).NotTo(HaveOccurred())
+		container.Args[0] = "changed"
+		Expect(cfg.Containers[0].Args).To(Equal([]string{"arg"}))
+
+		volume, err := cfg.index.volume("test-volume")
+		Expect(err).NotTo(HaveOccurred())
+		volume.EmptyDir.Medium = corev1.StorageMediumMemory
+		Expect(cfg.Volumes[0].EmptyDir.Medium).To(BeEmpty())
+
+		_, err = cfg.index.container("missing-container")
+		Expect(err).To(HaveOccurred())
+		_, err = cfg.index.volume("missing-volume")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,243 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+)
+
+// podTemplatePaths contains the paths of the Pod templates for all supported
+// workloads. For Pods the path is empty, because the Pod itself is injected.
+var podTemplatePaths = map[string][]string{
+	"Pod":         {},
+	"Deployment":  {"spec", "template"},
+	"StatefulSet": {"spec", "template"},
+	"DaemonSet":   {"spec", "template"},
+	"ReplicaSet":  {"spec", "template"},
+	"Job":         {"spec", "template"},
+	"CronJob":     {"spec", "jobTemplate", "spec", "template"},
+}
+
+// InjectManifests reads Kubernetes manifests from the given reader, injects
+// the resources into all Pods and the Pod templates of all workloads and
+// writes the mutated manifests as YAML to the given writer. The manifests can
+// be a stream of YAML or JSON documents and can contain Lists.
+//
+// The same logic as for admission requests is used, so that the output
+// matches the Pods created in the cluster. Since the manifests are injected
+// offline, the namespace selectors of the injectors are evaluated against the
+// Namespaces contained in the manifests.
+func (i *Injector) InjectManifests(ctx context.Context, r io.Reader, w io.Writer) error {
+	var objs []map[string]any
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj map[string]any
+		if err := decoder.Decode(&obj); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		if obj == nil {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+
+	// We use a copy of the injector, so that the Namespaces of the manifests
+	// are only used for this call.
+	injector := *i
+	injector.namespaces = make(map[string]*corev1.Namespace)
+	for _, obj := range objs {
+		collectNamespaces(obj, injector.namespaces)
+	}
+
+	for index, obj := range objs {
+		if err := injector.injectManifest(ctx, obj); err != nil {
+			return err
+		}
+
+		if index > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectNamespaces adds all Namespaces from the given manifest to the given
+// map.
+func collectNamespaces(obj map[string]any, namespaces map[string]*corev1.Namespace) {
+	switch obj["kind"] {
+	case "List":
+		items, _ := obj["items"].([]any)
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				collectNamespaces(item, namespaces)
+			}
+		}
+
+	case "Namespace":
+		namespace := &corev1.Namespace{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, namespace); err == nil {
+			namespaces[namespace.Name] = namespace
+		}
+	}
+}
+
+// injectManifest injects the resources into the Pod template of the given
+// manifest. Manifests without a Pod template are not changed.
+func (i *Injector) injectManifest(ctx context.Context, obj map[string]any) error {
+	kind, _ := obj["kind"].(string)
+	if kind == "List" {
+		items, _ := obj["items"].([]any)
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				if err := i.injectManifest(ctx, item); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	path, ok := podTemplatePaths[kind]
+	if !ok {
+		return nil
+	}
+
+	metadata, _ := obj["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	template := obj
+	for _, key := range path {
+		next, ok := template[key].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s %s/%s: missing field %q", kind, namespace, name, key)
+		}
+		template = next
+	}
+
+	injected, err := i.injectPodTemplate(ctx, kind, name, namespace, template)
+	if err != nil {
+		return fmt.Errorf("%s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	// For workloads we only take the metadata and the spec of the injected
+	// Pod, because a Pod template doesn't contain any other fields.
+	if kind == "Pod" {
+		delete(injected, "status")
+		for key := range obj {
+			delete(obj, key)
+		}
+		for key, value := range injected {
+			obj[key] = value
+		}
+		return nil
+	}
+
+	template["metadata"] = injected["metadata"]
+	template["spec"] = injected["spec"]
+	return nil
+}
+
+// injectPodTemplate calls the Handle function of the injector for a Pod
+// created from the given Pod template and returns the injected Pod.
+func (i *Injector) injectPodTemplate(ctx context.Context, kind, name, namespace string, template map[string]any) (map[string]any, error) {
+	// The Pods of a workload do not have a name, when they are created, so
+	// that we only set the generate name. The metadata fields which are not
+	// part of the template are removed again after the injection.
+	metadata, _ := template["metadata"].(map[string]any)
+	pod := map[string]any{"metadata": map[string]any{}, "spec": template["spec"]}
+	for key, value := range metadata {
+		pod["metadata"].(map[string]any)[key] = value
+	}
+	if kind == "Pod" {
+		for key, value := range template {
+			if key != "metadata" {
+				pod[key] = value
+			}
+		}
+	} else {
+		pod["metadata"].(map[string]any)["generateName"] = name + "-"
+		pod["metadata"].(map[string]any)["namespace"] = namespace
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	podName := name
+	if kind != "Pod" {
+		podName = ""
+	}
+	res := i.Handle(ctx, admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Name:      podName,
+			Namespace: namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !res.Allowed {
+		return nil, fmt.Errorf("injection failed: %s", res.Result.Message)
+	}
+	for _, warning := range res.Warnings {
+		log.Info("Injection warning.", "kind", kind, "name", name, "namespace", namespace, "warning", warning)
+	}
+
+	patched := raw
+	if len(res.Patches) > 0 {
+		rawPatch, err := json.Marshal(res.Patches)
+		if err != nil {
+			return nil, err
+		}
+		patch, err := jsonpatch.DecodePatch(rawPatch)
+		if err != nil {
+			return nil, err
+		}
+		patched, err = patch.Apply(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	injected := make(map[string]any)
+	if err := json.Unmarshal(patched, &injected); err != nil {
+		return nil, err
+	}
+
+	if kind != "Pod" {
+		injectedMetadata, _ := injected["metadata"].(map[string]any)
+		delete(injectedMetadata, "generateName")
+		delete(injectedMetadata, "namespace")
+	}
+
+	return injected, nil
+}
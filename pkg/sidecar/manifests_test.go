@@ -0,0 +1,131 @@
+package sidecar
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Manifests", func() {
+	Context("Injecting manifests", func() {
+		injector := &Injector{
+			Config: &Config{
+				Injectors: []InjectorData{
+					{
+						Name:              "logging",
+						Selector:          metav1.LabelSelector{MatchLabels: map[string]string{"logging": "true"}},
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "logging"}},
+						Containers:        []string{"log-shipper"},
+					},
+				},
+				Containers: []Container{
+					{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper:{{ .Namespace }}"}, Templates: true},
+				},
+			},
+			Decoder: admission.NewDecoder(scheme.Scheme),
+		}
+
+		manifests := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: logging
+  labels:
+    team: logging
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: logging
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        logging: "true"
+    spec:
+      containers:
+        - name: app
+          image: app
+---
+{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "other", "namespace": "other"}, "spec": {"template": {"metadata": {"labels": {"logging": "true"}}, "spec": {"containers": [{"name": "app", "image": "app"}]}}}}
+`
+
+		It("Should inject the Pod templates of workloads", func() {
+			out := &bytes.Buffer{}
+			Expect(injector.InjectManifests(ctx, strings.NewReader(manifests), out)).To(Succeed())
+
+			decoder := utilyaml.NewYAMLOrJSONDecoder(out, 4096)
+
+			namespace := &corev1.Namespace{}
+			Expect(decoder.Decode(namespace)).To(Succeed())
+			Expect(namespace.Labels).To(Equal(map[string]string{"team": "logging"}))
+
+			deployment := &appsv1.Deployment{}
+			Expect(decoder.Decode(deployment)).To(Succeed())
+			Expect(*deployment.Spec.Replicas).To(Equal(int32(2)))
+			Expect(deployment.Spec.Template.Annotations).To(HaveKeyWithValue(annotationStatusKey, "injected"))
+			Expect(deployment.Spec.Template.Namespace).To(BeEmpty())
+			Expect(deployment.Spec.Template.GenerateName).To(BeEmpty())
+			Expect(deployment.Spec.Template.Spec.Containers).To(HaveLen(2))
+			Expect(deployment.Spec.Template.Spec.Containers[1].Image).To(Equal("log-shipper:logging"))
+
+			// The namespace of the second Deployment is not contained in the
+			// manifests, so that the namespace selector doesn't match.
+			deployment = &appsv1.Deployment{}
+			Expect(decoder.Decode(deployment)).To(Succeed())
+			Expect(deployment.Spec.Template.Annotations).To(BeEmpty())
+			Expect(deployment.Spec.Template.Spec.Containers).To(HaveLen(1))
+		})
+
+		It("Should inject Pods", func() {
+			pod := `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  annotations:
+    sidecar-injector.ricoberger.de: enabled
+    sidecar-injector.ricoberger.de/containers: log-shipper
+spec:
+  containers:
+    - name: app
+      image: app
+`
+
+			out := &bytes.Buffer{}
+			Expect(injector.InjectManifests(ctx, strings.NewReader(pod), out)).To(Succeed())
+
+			injectedPod := &corev1.Pod{}
+			Expect(utilyaml.NewYAMLOrJSONDecoder(out, 4096).Decode(injectedPod)).To(Succeed())
+			Expect(injectedPod.Name).To(Equal("app"))
+			Expect(injectedPod.Spec.Containers).To(HaveLen(2))
+			Expect(out.String()).NotTo(ContainSubstring("status"))
+		})
+
+		It("Should return an error when the injection fails", func() {
+			pod := `apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+  annotations:
+    sidecar-injector.ricoberger.de: enabled
+    sidecar-injector.ricoberger.de/containers: log-shipper
+spec:
+  containers:
+    - name: log-shipper
+      image: app
+`
+
+			err := injector.InjectManifests(ctx, strings.NewReader(pod), &bytes.Buffer{})
+			Expect(err).To(MatchError(`Pod default/app: injection failed: container "log-shipper" can not be injected, because the Pod already contains a container with the same name`))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package sidecar
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The outcomes and reasons of admission requests, which are used as labels for
+// the admission requests metric.
+const (
+	outcomeInjected        = "injected"
+	outcomeSkipped         = "skipped"
+	outcomeAlreadyInjected = "already-injected"
+	outcomeDenied          = "denied"
+	outcomeErrored         = "errored"
+
+	reasonInjectors       = "injectors"
+	reasonAnnotations     = "annotations"
+	reasonAlreadyInjected = "status-annotation"
+	reasonNoMatch         = "no-match"
+	reasonDisabled        = "disabled"
+	reasonExcluded        = "excluded"
+	reasonUpdate          = "update"
+)
+
+var (
+	admissionRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sidecar_injector",
+		Name:      "admission_requests_total",
+		Help:      "Total number of admission requests by operation, outcome and reason.",
+	}, []string{"operation", "outcome", "reason"})
+
+	admissionDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sidecar_injector",
+		Name:      "admission_duration_seconds",
+		Help:      "Latency of the admission requests by operation.",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"operation"})
+
+	injectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sidecar_injector",
+		Name:      "injected_total",
+		Help:      "Total number of injected init containers, containers and volumes by kind, name and injector.",
+	}, []string{"kind", "name", "injector"})
+
+	configGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "sidecar_injector",
+		Name:      "config_generation",
+		Help:      "Generation of the loaded configuration, which is increased on each successful reload.",
+	})
+
+	outdatedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sidecar_injector",
+		Name:      "outdated_pods",
+		Help:      "Number of Pods with outdated injected resources by the namespace, kind and name of the workload.",
+	}, []string{"namespace", "kind", "name"})
+
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sidecar_injector",
+		Name:      "config_reloads_total",
+		Help:      "Total number of configuration reloads by result.",
+	}, []string{"result"})
+
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "sidecar_injector",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload was successful.",
+	})
+
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "sidecar_injector",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		admissionRequestsTotal, admissionDurationSeconds, injectedTotal, outdatedPods,
+		configGeneration, configReloadsTotal, configLastReloadSuccessful, configLastReloadSuccessTimestamp,
+	)
+}
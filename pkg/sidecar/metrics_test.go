@@ -0,0 +1,57 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Metrics", func() {
+	Context("Handling admission requests", func() {
+		injector := &Injector{
+			Config: &Config{
+				Injectors: []InjectorData{
+					{
+						Name:       "metrics",
+						Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "metrics"}},
+						Containers: []string{"metrics-container"},
+					},
+				},
+				Containers: []Container{
+					{Container: corev1.Container{Name: "metrics-container", Image: "metrics-image"}},
+				},
+			},
+			Decoder: admission.NewDecoder(scheme.Scheme),
+		}
+
+		It("Should record the outcome of admission requests and the injected resources", func() {
+			injectedRequests := testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeInjected, reasonInjectors))
+			skippedRequests := testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeSkipped, reasonNoMatch))
+			erroredRequests := testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeErrored, "decode"))
+			injectedContainers := testutil.ToFloat64(injectedTotal.WithLabelValues(kindContainer, "metrics-container", "metrics"))
+
+			_, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "default", Labels: map[string]string{"app": "metrics"}},
+			})
+			Expect(res.Allowed).To(BeTrue())
+
+			_, res = handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "metrics", Namespace: "default"},
+			})
+			Expect(res.Allowed).To(BeTrue())
+
+			res = injector.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create}})
+			Expect(res.Allowed).To(BeFalse())
+
+			Expect(testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeInjected, reasonInjectors))).To(Equal(injectedRequests + 1))
+			Expect(testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeSkipped, reasonNoMatch))).To(Equal(skippedRequests + 1))
+			Expect(testutil.ToFloat64(admissionRequestsTotal.WithLabelValues("CREATE", outcomeErrored, "decode"))).To(Equal(erroredRequests + 1))
+			Expect(testutil.ToFloat64(injectedTotal.WithLabelValues(kindContainer, "metrics-container", "metrics"))).To(Equal(injectedContainers + 1))
+		})
+	})
+})
@@ -0,0 +1,256 @@
+package sidecar
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// resourcesAnnotationSuffix is the suffix of the annotation, which
+	// contains all resource overrides of a container as JSON or YAML.
+	resourcesAnnotationSuffix = "resources"
+
+	// resourceValueNone is the value of a resource annotation, which removes
+	// the request or limit from the container.
+	resourceValueNone = "none"
+)
+
+// resourceAnnotation is an annotation, which overwrites a single request or
+// limit of a container. The name of the annotation is the annotation key for
+// containers or init containers, the name of the container and the suffix.
+type resourceAnnotation struct {
+	suffix string
+	name   corev1.ResourceName
+	limit  bool
+}
+
+// resourceAnnotations are the annotations, which are used by setResources to
+// overwrite a single request or limit. Extended resources can only be set via
+// the resources annotation, because their names contain a slash, which is not
+// allowed in the name of an annotation.
+var resourceAnnotations = []resourceAnnotation{
+	{suffix: "cpurequests", name: corev1.ResourceCPU},
+	{suffix: "cpulimits", name: corev1.ResourceCPU, limit: true},
+	{suffix: "memoryrequests", name: corev1.ResourceMemory},
+	{suffix: "memorylimits", name: corev1.ResourceMemory, limit: true},
+	{suffix: "ephemeralstoragerequests", name: corev1.ResourceEphemeralStorage},
+	{suffix: "ephemeralstoragelimits", name: corev1.ResourceEphemeralStorage, limit: true},
+}
+
+// resourceOverrides is the content of the resources annotation. A request or
+// limit with a null value is removed from the container.
+type resourceOverrides struct {
+	Requests map[corev1.ResourceName]*resource.Quantity `json:"requests,omitempty"`
+	Limits   map[corev1.ResourceName]*resource.Quantity `json:"limits,omitempty"`
+}
+
+// resourceOverride is a single request or limit, which should be overwritten.
+// If the quantity is nil, the request or limit is removed.
+type resourceOverride struct {
+	name     corev1.ResourceName
+	limit    bool
+	quantity *resource.Quantity
+}
+
+// kind returns "limits" or "requests", which is used in the messages for the
+// override.
+func (o resourceOverride) kind() string {
+	if o.limit {
+		return "limits"
+	}
+	return "requests"
+}
+
+// parseResourceOverrides parses the value of the resources annotation, which
+// can be JSON or YAML.
+func parseResourceOverrides(value string) (*resourceOverrides, error) {
+	overrides := &resourceOverrides{}
+	if err := yaml.UnmarshalStrict([]byte(value), overrides); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// parseResourceValue parses the value of an annotation for a single request or
+// limit. For the value "none" nil is returned, so that the request or limit is
+// removed.
+func parseResourceValue(value string) (*resource.Quantity, error) {
+	if value == resourceValueNone {
+		return nil, nil
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quantity, nil
+}
+
+// getResourceOverrides returns the overrides for the container with the given
+// name from the given annotations. The overrides from the resources annotation
+// are returned first, so that they can be overwritten by the annotations for a
+// single request or limit. It also returns a message for each annotation,
+// which could not be parsed. The source is added to the messages.
+func getResourceOverrides(containerName, annotationKey string, annotations map[string]string, source string) ([]resourceOverride, []string) {
+	var overrides []resourceOverride
+	var invalid []string
+
+	annotation := fmt.Sprintf("%s-%s-%s", annotationKey, containerName, resourcesAnnotationSuffix)
+	if val, ok := annotations[annotation]; ok && val != "" {
+		parsed, err := parseResourceOverrides(val)
+		if err != nil {
+			log.Error(err, "Could not parse resources.", "containerName", containerName, "annotation", annotation, "value", val)
+			invalid = append(invalid, fmt.Sprintf("could not parse resources %q from annotation %q%s: %s", val, annotation, source, err.Error()))
+		} else {
+			for _, name := range sortedResourceNames(toResourceList(parsed.Requests)) {
+				overrides = append(overrides, resourceOverride{name: name, quantity: parsed.Requests[name]})
+			}
+			for _, name := range sortedResourceNames(toResourceList(parsed.Limits)) {
+				overrides = append(overrides, resourceOverride{name: name, limit: true, quantity: parsed.Limits[name]})
+			}
+		}
+	}
+
+	for _, resourceAnnotation := range resourceAnnotations {
+		annotation := fmt.Sprintf("%s-%s-%s", annotationKey, containerName, resourceAnnotation.suffix)
+		val, ok := annotations[annotation]
+		if !ok || val == "" {
+			continue
+		}
+
+		override := resourceOverride{name: resourceAnnotation.name, limit: resourceAnnotation.limit}
+		quantity, err := parseResourceValue(val)
+		if err != nil {
+			log.Error(err, "Could not parse resource.", "containerName", containerName, "annotation", annotation, "value", val)
+			invalid = append(invalid, fmt.Sprintf("could not parse %s %s %q from annotation %q%s", override.name, override.kind(), val, annotation, source))
+			continue
+		}
+
+		override.quantity = quantity
+		overrides = append(overrides, override)
+	}
+
+	return overrides, invalid
+}
+
+// toResourceList returns a resource list with the names of the given
+// overrides, which is used to sort the names.
+func toResourceList(overrides map[corev1.ResourceName]*resource.Quantity) corev1.ResourceList {
+	list := make(corev1.ResourceList, len(overrides))
+	for name := range overrides {
+		list[name] = resource.Quantity{}
+	}
+
+	return list
+}
+
+// resourceMessages are the messages of setResources. They are kept separately,
+// because only the invalid annotations of the Pod can be fixed by the creator
+// of the Pod and are therefore denied in strict mode.
+type resourceMessages struct {
+	// invalid contains a message for each annotation of the Pod, which could
+	// not be parsed.
+	invalid []string
+	// namespace contains a message for each annotation of the Namespace,
+	// which could not be parsed.
+	namespace []string
+	// clamped contains a message for each request or limit, which was changed
+	// to stay within the bounds.
+	clamped []string
+}
+
+// setResources sets the resources of the container from the annotations of
+// the Namespace and the Pod. The annotations of the Namespace are used as
+// defaults, which can be overwritten by the annotations of the Pod. The
+// overwritten requests and limits are limited by the given bounds.
+//
+// It returns the updated container and the messages for the annotations,
+// which could not be parsed, and for the requests and limits, which were
+// changed to stay within the bounds.
+func setResources(container corev1.Container, annotationKey string, namespaceAnnotations, annotations map[string]string, bounds *ResourceBounds) (corev1.Container, resourceMessages) {
+	var overrides []resourceOverride
+	var messages resourceMessages
+
+	namespaceOverrides, invalid := getResourceOverrides(container.Name, annotationKey, namespaceAnnotations, " of the Namespace")
+	overrides = append(overrides, namespaceOverrides...)
+	messages.namespace = invalid
+
+	podOverrides, invalid := getResourceOverrides(container.Name, annotationKey, annotations, "")
+	overrides = append(overrides, podOverrides...)
+	messages.invalid = invalid
+
+	// The maps of the resources are nil, when the definition of the container
+	// doesn't contain any requests or limits, so that they are created before
+	// a quantity is set.
+	for _, override := range overrides {
+		list := &container.Resources.Requests
+		if override.limit {
+			list = &container.Resources.Limits
+		}
+
+		if override.quantity == nil {
+			delete(*list, override.name)
+			continue
+		}
+		if *list == nil {
+			*list = make(corev1.ResourceList)
+		}
+		(*list)[override.name] = *override.quantity
+	}
+
+	if bounds != nil {
+		messages.clamped = bounds.apply(container.Name, &container.Resources, overrides)
+	}
+
+	return container, messages
+}
+
+// apply changes the overwritten requests and limits of the given resources,
+// which are outside of the bounds, to the minimum or maximum. A limit with a
+// maximum can not be removed, so that it is set to the maximum. It returns a
+// message for each changed request or limit.
+func (b *ResourceBounds) apply(containerName string, resources *corev1.ResourceRequirements, overrides []resourceOverride) []string {
+	var messages []string
+	checked := make(map[string]bool)
+
+	for _, override := range overrides {
+		key := override.kind() + "/" + string(override.name)
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		list := &resources.Requests
+		if override.limit {
+			list = &resources.Limits
+		}
+
+		max, hasMax := b.Max[override.name]
+		quantity, ok := (*list)[override.name]
+		if !ok {
+			if override.limit && hasMax {
+				if *list == nil {
+					*list = make(corev1.ResourceList)
+				}
+				(*list)[override.name] = max
+				messages = append(messages, fmt.Sprintf("%s %s of container %q can not be removed and were set to the maximum of %q", override.name, override.kind(), containerName, max.String()))
+			}
+			continue
+		}
+
+		if min, ok := b.Min[override.name]; ok && quantity.Cmp(min) < 0 {
+			(*list)[override.name] = min
+			messages = append(messages, fmt.Sprintf("%s %s %q of container %q were raised to the minimum of %q", override.name, override.kind(), quantity.String(), containerName, min.String()))
+		} else if hasMax && quantity.Cmp(max) > 0 {
+			(*list)[override.name] = max
+			messages = append(messages, fmt.Sprintf("%s %s %q of container %q were lowered to the maximum of %q", override.name, override.kind(), quantity.String(), containerName, max.String()))
+		}
+	}
+
+	return messages
+}
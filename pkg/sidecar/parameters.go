@@ -0,0 +1,137 @@
+package sidecar
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// hasParameter returns true, when the volume has a parameter for the given
+// annotation.
+func (v Volume) hasParameter(annotation string) bool {
+	return slices.ContainsFunc(v.Parameters, func(p VolumeParameter) bool { return p.Annotation == annotation })
+}
+
+// compile compiles the pattern of the parameter. The pattern is anchored, so
+// that it must match the complete value of the annotation.
+func (p VolumeParameter) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + p.Pattern + ")$")
+}
+
+// supports returns true, when the given volume has a source, which contains
+// the field.
+func (f VolumeParameterField) supports(volume corev1.Volume) bool {
+	switch f {
+	case VolumeParameterSecretName:
+		return volume.Secret != nil || hasProjectedSource(volume, func(s corev1.VolumeProjection) bool { return s.Secret != nil })
+	case VolumeParameterConfigMapName:
+		return volume.ConfigMap != nil || hasProjectedSource(volume, func(s corev1.VolumeProjection) bool { return s.ConfigMap != nil })
+	case VolumeParameterAudience, VolumeParameterExpirationSeconds:
+		return hasProjectedSource(volume, func(s corev1.VolumeProjection) bool { return s.ServiceAccountToken != nil })
+	case VolumeParameterSizeLimit:
+		return volume.EmptyDir != nil
+	default:
+		return false
+	}
+}
+
+func hasProjectedSource(volume corev1.Volume, f func(corev1.VolumeProjection) bool) bool {
+	return volume.Projected != nil && slices.ContainsFunc(volume.Projected.Sources, f)
+}
+
+// set sets the field of the given volume to the given value. It returns an
+// error, when the value can not be parsed for the field.
+func (f VolumeParameterField) set(volume *corev1.Volume, value string) error {
+	switch f {
+	case VolumeParameterSecretName:
+		if volume.Secret != nil {
+			volume.Secret.SecretName = value
+		}
+		forEachProjectedSource(volume, func(s *corev1.VolumeProjection) {
+			if s.Secret != nil {
+				s.Secret.Name = value
+			}
+		})
+
+	case VolumeParameterConfigMapName:
+		if volume.ConfigMap != nil {
+			volume.ConfigMap.Name = value
+		}
+		forEachProjectedSource(volume, func(s *corev1.VolumeProjection) {
+			if s.ConfigMap != nil {
+				s.ConfigMap.Name = value
+			}
+		})
+
+	case VolumeParameterAudience:
+		forEachProjectedSource(volume, func(s *corev1.VolumeProjection) {
+			if s.ServiceAccountToken != nil {
+				s.ServiceAccountToken.Audience = value
+			}
+		})
+
+	case VolumeParameterExpirationSeconds:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		forEachProjectedSource(volume, func(s *corev1.VolumeProjection) {
+			if s.ServiceAccountToken != nil {
+				s.ServiceAccountToken.ExpirationSeconds = &seconds
+			}
+		})
+
+	case VolumeParameterSizeLimit:
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return err
+		}
+		if volume.EmptyDir != nil {
+			volume.EmptyDir.SizeLimit = &quantity
+		}
+	}
+
+	return nil
+}
+
+func forEachProjectedSource(volume *corev1.Volume, f func(*corev1.VolumeProjection)) {
+	if volume.Projected == nil {
+		return
+	}
+	for index := range volume.Projected.Sources {
+		f(&volume.Projected.Sources[index])
+	}
+}
+
+// setVolumeParameters sets the fields of the volume from the annotations of the
+// Pod. Parameters without an annotation are ignored, so that the field from
+// the definition is used.
+//
+// It returns the updated volume and a message for each annotation, which
+// value is not allowed by the pattern of the parameter or can not be parsed.
+func setVolumeParameters(volume corev1.Volume, parameters []compiledVolumeParameter, annotations map[string]string) (corev1.Volume, []string) {
+	var messages []string
+
+	for _, parameter := range parameters {
+		value, ok := annotations[parameter.Annotation]
+		if !ok || value == "" {
+			continue
+		}
+
+		if !parameter.pattern.MatchString(value) {
+			messages = append(messages, fmt.Sprintf("%s %q from annotation %q is not allowed for volume %q", parameter.Field, value, parameter.Annotation, volume.Name))
+			continue
+		}
+
+		if err := parameter.Field.set(&volume, value); err != nil {
+			log.Error(err, "Could not parse volume parameter.", "volume", volume.Name, "annotation", parameter.Annotation, "value", value)
+			messages = append(messages, fmt.Sprintf("could not parse %s %q from annotation %q for volume %q", parameter.Field, value, parameter.Annotation, volume.Name))
+		}
+	}
+
+	return volume, messages
+}
@@ -0,0 +1,139 @@
+package sidecar
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	pathInitContainers = "/spec/initContainers"
+	pathContainers     = "/spec/containers"
+	pathVolumes        = "/spec/volumes"
+	pathAnnotations    = "/metadata/annotations"
+	pathLabels         = "/metadata/labels"
+
+	pathImagePullSecrets              = "/spec/imagePullSecrets"
+	pathShareProcessNamespace         = "/spec/shareProcessNamespace"
+	pathHostAliases                   = "/spec/hostAliases"
+	pathDNSConfig                     = "/spec/dnsConfig"
+	pathTolerations                   = "/spec/tolerations"
+	pathTerminationGracePeriodSeconds = "/spec/terminationGracePeriodSeconds"
+)
+
+// podPatch collects the JSON patch operations for all changes of a Pod. The
+// operations are created while the Pod is changed, so that they are applied in
+// the same order as the changes. Since only the changed fields are part of the
+// patch, we do not have to marshal the Pod and all fields of the Pod which are
+// unknown to the sidecar injector are preserved.
+type podPatch struct {
+	operations []jsonpatch.JsonPatchOperation
+}
+
+// appendContainer appends the given container to the init containers or
+// containers of the given Pod. Native sidecar containers are inserted before
+// the first regular init container, so that they are already running, when the
+// init containers of the Pod are started.
+func (p *podPatch) appendContainer(pod *corev1.Pod, container corev1.Container, initContainer bool) {
+	if initContainer && isNativeSidecar(container) {
+		index := slices.IndexFunc(pod.Spec.InitContainers, func(c corev1.Container) bool { return !isNativeSidecar(c) })
+		if index >= 0 {
+			p.insert(pathInitContainers, index, container)
+			pod.Spec.InitContainers = slices.Insert(pod.Spec.InitContainers, index, container)
+			return
+		}
+	}
+
+	if initContainer {
+		p.append(pathInitContainers, len(pod.Spec.InitContainers), container)
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+	} else {
+		p.append(pathContainers, len(pod.Spec.Containers), container)
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+	}
+}
+
+// appendVolume appends the given volume to the volumes of the given Pod.
+func (p *podPatch) appendVolume(pod *corev1.Pod, volume corev1.Volume) {
+	p.append(pathVolumes, len(pod.Spec.Volumes), volume)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+}
+
+// append adds an operation, which appends the given value to the list at the
+// given path. If the list is empty, it might not exist in the Pod, so that the
+// whole list is added.
+func (p *podPatch) append(path string, length int, value any) {
+	if length == 0 {
+		p.operations = append(p.operations, jsonpatch.NewOperation("add", path, []any{value}))
+		return
+	}
+	p.operations = append(p.operations, jsonpatch.NewOperation("add", path+"/-", value))
+}
+
+// insert adds an operation, which inserts the given value at the given index
+// into the list at the given path.
+func (p *podPatch) insert(path string, index int, value any) {
+	p.operations = append(p.operations, jsonpatch.NewOperation("add", path+"/"+strconv.Itoa(index), value))
+}
+
+// remove adds an operation, which removes the item with the given index from
+// the list at the given path.
+func (p *podPatch) remove(path string, index int) {
+	p.operations = append(p.operations, jsonpatch.NewOperation("remove", path+"/"+strconv.Itoa(index), nil))
+}
+
+// replace adds an operation, which replaces the item with the given index in
+// the list at the given path with the given value.
+func (p *podPatch) replace(path string, index int, value any) {
+	p.operations = append(p.operations, jsonpatch.NewOperation("replace", path+"/"+strconv.Itoa(index), value))
+}
+
+// set adds an operation, which sets the field at the given path to the given
+// value. The "add" operation replaces the value, when the field exists.
+func (p *podPatch) set(path string, value any) {
+	p.operations = append(p.operations, jsonpatch.NewOperation("add", path, value))
+}
+
+// setAnnotations sets the given annotations in the given Pod.
+func (p *podPatch) setAnnotations(pod *corev1.Pod, annotations map[string]string) {
+	pod.Annotations = p.setMap(pathAnnotations, pod.Annotations, annotations)
+}
+
+// setLabels sets the given labels in the given Pod.
+func (p *podPatch) setLabels(pod *corev1.Pod, labels map[string]string) {
+	pod.Labels = p.setMap(pathLabels, pod.Labels, labels)
+}
+
+// setMap sets the given values in the map at the given path and returns the
+// updated map. If the map is nil, the values are added as a whole, because the
+// field might not exist in the Pod.
+func (p *podPatch) setMap(path string, m map[string]string, values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return m
+	}
+
+	if m == nil {
+		m = make(map[string]string, len(values))
+		for key, value := range values {
+			m[key] = value
+		}
+		p.operations = append(p.operations, jsonpatch.NewOperation("add", path, values))
+		return m
+	}
+
+	for _, key := range sortedKeys(values) {
+		m[key] = values[key]
+		p.operations = append(p.operations, jsonpatch.NewOperation("add", path+"/"+escapeJSONPointer(key), values[key]))
+	}
+
+	return m
+}
+
+// escapeJSONPointer escapes the given value, so that it can be used as a
+// reference token in a JSON pointer, see RFC 6901.
+func escapeJSONPointer(value string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(value, "~", "~0"), "/", "~1")
+}
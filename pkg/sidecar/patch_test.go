@@ -0,0 +1,107 @@
+package sidecar
+
+import (
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Patch", func() {
+	injector := &Injector{
+		Config: &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:       metav1.LabelSelector{MatchLabels: map[string]string{"app": "patch"}},
+					InitContainers: []string{"setup"},
+					Containers:     []string{"log-shipper", "app"},
+					Volumes:        []string{"logs", "data"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "setup", Image: "setup"}},
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper"}},
+				{Container: corev1.Container{Name: "app", Image: "app:injected"}, OnConflict: ConflictStrategyReplace},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+				{Volume: corev1.Volume{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}, OnConflict: ConflictStrategyReplace},
+			},
+		},
+		Decoder: admission.NewDecoder(scheme.Scheme),
+	}
+
+	// apply calls the Handle function of the injector for the given raw Pod
+	// and returns the patched raw Pod.
+	apply := func(raw string) (map[string]any, admission.Response) {
+		res := injector.Handle(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Name:      "patch",
+				Namespace: "default",
+				Object:    runtime.RawExtension{Raw: []byte(raw)},
+			},
+		})
+		Expect(res.Allowed).To(BeTrue())
+
+		rawPatch, err := json.Marshal(res.Patches)
+		Expect(err).NotTo(HaveOccurred())
+		patch, err := jsonpatch.DecodePatch(rawPatch)
+		Expect(err).NotTo(HaveOccurred())
+		patched, err := patch.Apply([]byte(raw))
+		Expect(err).NotTo(HaveOccurred())
+
+		obj := make(map[string]any)
+		Expect(json.Unmarshal(patched, &obj)).To(Succeed())
+		return obj, res
+	}
+
+	Context("Creating targeted patches", func() {
+		It("Should only patch the changed fields", func() {
+			_, res := apply(`{"metadata":{"name":"patch","labels":{"app":"patch"}},"spec":{"containers":[{"name":"sidecar","image":"sidecar"},{"name":"app","image":"app"}],"volumes":[{"name":"data","hostPath":{"path":"/data"}}]}}`)
+
+			var operations []string
+			for _, operation := range res.Patches {
+				operations = append(operations, operation.Operation+" "+operation.Path)
+			}
+			Expect(operations).To(Equal([]string{
+				"add /spec/initContainers",
+				"add /spec/containers/-",
+				"remove /spec/containers/1",
+				"add /spec/containers/-",
+				"add /spec/volumes/-",
+				"replace /spec/volumes/0",
+				"add /metadata/annotations",
+			}))
+		})
+
+		It("Should preserve unknown fields of the Pod", func() {
+			obj, _ := apply(`{"metadata":{"name":"patch","labels":{"app":"patch"},"annotations":{"a/b~c":"value"}},"spec":{"futureField":{"enabled":true},"containers":[{"name":"sidecar","image":"sidecar","futureField":"value"}]}}`)
+
+			spec := obj["spec"].(map[string]any)
+			Expect(spec["futureField"]).To(Equal(map[string]any{"enabled": true}))
+
+			containers := spec["containers"].([]any)
+			Expect(containers).To(HaveLen(3))
+			Expect(containers[0].(map[string]any)["futureField"]).To(Equal("value"))
+			Expect(containers[0].(map[string]any)).NotTo(HaveKey("resources"))
+
+			annotations := obj["metadata"].(map[string]any)["annotations"].(map[string]any)
+			Expect(annotations).To(HaveKeyWithValue("a/b~c", "value"))
+			Expect(annotations).To(HaveKeyWithValue(annotationStatusKey, "injected"))
+			Expect(obj).NotTo(HaveKey("status"))
+		})
+
+		It("Should escape the annotation keys", func() {
+			Expect(escapeJSONPointer("sidecar-injector.ricoberger.de/status")).To(Equal("sidecar-injector.ricoberger.de~1status"))
+			Expect(escapeJSONPointer("a~b/c")).To(Equal("a~0b~1c"))
+		})
+	})
+})
@@ -0,0 +1,235 @@
+package sidecar
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/utils/ptr"
+)
+
+// podMutation is a mutation for the metadata and the specification of a Pod
+// together with the name of the injector, which defines the mutation.
+type podMutation struct {
+	injector string
+	mutation PodMutation
+}
+
+// deepCopy returns a copy of the mutation, so that the templates of the copy
+// can be rendered without changing the configuration.
+func (m PodMutation) deepCopy() PodMutation {
+	out := PodMutation{
+		Labels:      maps.Clone(m.Labels),
+		Annotations: maps.Clone(m.Annotations),
+		OnConflict:  m.OnConflict,
+		Templates:   m.Templates,
+	}
+	for _, secret := range m.ImagePullSecrets {
+		out.ImagePullSecrets = append(out.ImagePullSecrets, *secret.DeepCopy())
+	}
+	for _, hostAlias := range m.HostAliases {
+		out.HostAliases = append(out.HostAliases, *hostAlias.DeepCopy())
+	}
+	for _, toleration := range m.Tolerations {
+		out.Tolerations = append(out.Tolerations, *toleration.DeepCopy())
+	}
+	if m.DNSConfig != nil {
+		out.DNSConfig = m.DNSConfig.DeepCopy()
+	}
+	if m.ShareProcessNamespace != nil {
+		out.ShareProcessNamespace = ptr.To(*m.ShareProcessNamespace)
+	}
+	if m.TerminationGracePeriodSeconds != nil {
+		out.TerminationGracePeriodSeconds = ptr.To(*m.TerminationGracePeriodSeconds)
+	}
+
+	return out
+}
+
+// mutatePod applies the given mutation to the metadata and the specification
+// of the Pod, see PodMutation for the semantics of each field. It returns the
+// names of the changed fields. If a label, annotation, DNS option or the
+// process namespace sharing already has a different value, the given conflict
+// strategy is applied. For the "Skip" and "Replace" strategies a warning is
+// returned, which describes the decision. Existing labels are never replaced,
+// because they might be used by the selectors of Services or workloads, so
+// that the "Replace" strategy is handled like "Skip" for labels.
+func mutatePod(pod *corev1.Pod, patch *podPatch, mutation PodMutation, strategy ConflictStrategy) ([]string, []string, error) {
+	var changed []string
+	var warnings []string
+
+	// resolveWith applies the given conflict strategy for the given
+	// description and returns true, when the value of the Pod should be
+	// replaced.
+	resolveWith := func(strategy ConflictStrategy, description string) (bool, error) {
+		switch strategy {
+		case ConflictStrategySkip:
+			warnings = append(warnings, fmt.Sprintf("%s was not set, because the Pod already contains a different value", description))
+			return false, nil
+		case ConflictStrategyReplace:
+			warnings = append(warnings, fmt.Sprintf("%s of the Pod was replaced", description))
+			return true, nil
+		default:
+			return false, fmt.Errorf("%s can not be set, because the Pod already contains a different value", description)
+		}
+	}
+	resolve := func(description string) (bool, error) {
+		return resolveWith(strategy, description)
+	}
+
+	// mergeMap returns the entries of the given values, which should be set
+	// in the given map of the Pod.
+	mergeMap := func(m, values map[string]string, kind string, strategy ConflictStrategy) (map[string]string, error) {
+		merged := make(map[string]string)
+		for _, key := range sortedKeys(values) {
+			if existing, ok := m[key]; ok {
+				if existing == values[key] {
+					continue
+				}
+				replace, err := resolveWith(strategy, fmt.Sprintf("%s %q", kind, key))
+				if err != nil {
+					return nil, err
+				}
+				if !replace {
+					continue
+				}
+			}
+			merged[key] = values[key]
+		}
+		return merged, nil
+	}
+
+	labelStrategy := strategy
+	if labelStrategy == ConflictStrategyReplace {
+		labelStrategy = ConflictStrategySkip
+	}
+	labels, err := mergeMap(pod.Labels, mutation.Labels, "label", labelStrategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(labels) > 0 {
+		patch.setLabels(pod, labels)
+		changed = append(changed, "labels")
+	}
+
+	annotations, err := mergeMap(pod.Annotations, mutation.Annotations, "annotation", strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(annotations) > 0 {
+		patch.setAnnotations(pod, annotations)
+		changed = append(changed, "annotations")
+	}
+
+	var imagePullSecretsChanged bool
+	for _, secret := range mutation.ImagePullSecrets {
+		if slices.ContainsFunc(pod.Spec.ImagePullSecrets, func(s corev1.LocalObjectReference) bool { return s.Name == secret.Name }) {
+			continue
+		}
+		patch.append(pathImagePullSecrets, len(pod.Spec.ImagePullSecrets), secret)
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, secret)
+		imagePullSecretsChanged = true
+	}
+	if imagePullSecretsChanged {
+		changed = append(changed, "imagePullSecrets")
+	}
+
+	if mutation.ShareProcessNamespace != nil {
+		set := pod.Spec.ShareProcessNamespace == nil
+		if !set && *pod.Spec.ShareProcessNamespace != *mutation.ShareProcessNamespace {
+			if set, err = resolve("process namespace sharing"); err != nil {
+				return nil, nil, err
+			}
+		}
+		if set {
+			patch.set(pathShareProcessNamespace, *mutation.ShareProcessNamespace)
+			pod.Spec.ShareProcessNamespace = ptr.To(*mutation.ShareProcessNamespace)
+			changed = append(changed, "shareProcessNamespace")
+		}
+	}
+
+	var hostAliasesChanged bool
+	for _, hostAlias := range mutation.HostAliases {
+		index := slices.IndexFunc(pod.Spec.HostAliases, func(h corev1.HostAlias) bool { return h.IP == hostAlias.IP })
+		if index < 0 {
+			patch.append(pathHostAliases, len(pod.Spec.HostAliases), hostAlias)
+			pod.Spec.HostAliases = append(pod.Spec.HostAliases, hostAlias)
+			hostAliasesChanged = true
+			continue
+		}
+
+		merged := *pod.Spec.HostAliases[index].DeepCopy()
+		merged.Hostnames = appendUnique(merged.Hostnames, hostAlias.Hostnames...)
+		if len(merged.Hostnames) != len(pod.Spec.HostAliases[index].Hostnames) {
+			patch.replace(pathHostAliases, index, merged)
+			pod.Spec.HostAliases[index] = merged
+			hostAliasesChanged = true
+		}
+	}
+	if hostAliasesChanged {
+		changed = append(changed, "hostAliases")
+	}
+
+	if mutation.DNSConfig != nil {
+		dnsConfig := &corev1.PodDNSConfig{}
+		if pod.Spec.DNSConfig != nil {
+			dnsConfig = pod.Spec.DNSConfig.DeepCopy()
+		}
+		dnsConfig.Nameservers = appendUnique(dnsConfig.Nameservers, mutation.DNSConfig.Nameservers...)
+		dnsConfig.Searches = appendUnique(dnsConfig.Searches, mutation.DNSConfig.Searches...)
+		for _, option := range mutation.DNSConfig.Options {
+			index := slices.IndexFunc(dnsConfig.Options, func(o corev1.PodDNSConfigOption) bool { return o.Name == option.Name })
+			if index < 0 {
+				dnsConfig.Options = append(dnsConfig.Options, option)
+				continue
+			}
+			if ptr.Equal(dnsConfig.Options[index].Value, option.Value) {
+				continue
+			}
+			replace, err := resolve(fmt.Sprintf("DNS option %q", option.Name))
+			if err != nil {
+				return nil, nil, err
+			}
+			if replace {
+				dnsConfig.Options[index] = option
+			}
+		}
+
+		original := pod.Spec.DNSConfig
+		if original == nil {
+			original = &corev1.PodDNSConfig{}
+		}
+		if !equality.Semantic.DeepEqual(dnsConfig, original) {
+			patch.set(pathDNSConfig, dnsConfig)
+			pod.Spec.DNSConfig = dnsConfig
+			changed = append(changed, "dnsConfig")
+		}
+	}
+
+	var tolerationsChanged bool
+	for _, toleration := range mutation.Tolerations {
+		if slices.ContainsFunc(pod.Spec.Tolerations, func(t corev1.Toleration) bool { return equality.Semantic.DeepEqual(t, toleration) }) {
+			continue
+		}
+		patch.append(pathTolerations, len(pod.Spec.Tolerations), toleration)
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+		tolerationsChanged = true
+	}
+	if tolerationsChanged {
+		changed = append(changed, "tolerations")
+	}
+
+	// The termination grace period is never lowered, because the application
+	// or another injected container might need the longer period.
+	if seconds := mutation.TerminationGracePeriodSeconds; seconds != nil {
+		if pod.Spec.TerminationGracePeriodSeconds == nil || *pod.Spec.TerminationGracePeriodSeconds < *seconds {
+			patch.set(pathTerminationGracePeriodSeconds, *seconds)
+			pod.Spec.TerminationGracePeriodSeconds = ptr.To(*seconds)
+			changed = append(changed, "terminationGracePeriodSeconds")
+		}
+	}
+
+	return changed, warnings, nil
+}
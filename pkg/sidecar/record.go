@@ -0,0 +1,83 @@
+package sidecar
+
+import (
+	"encoding/json"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// record is stored as JSON in the `sidecar-injector.ricoberger.de/record`
+// annotation of an injected Pod. It contains all init containers, containers
+// and volumes, which were injected into the Pod, together with the injectors
+// which caused the injection and the revision of the injected definitions.
+//
+// The init containers and containers are recorded where they were injected,
+// e.g. a container which was injected as native sidecar container is recorded
+// as init container. The app containers are the existing containers of the
+// Pod, which were changed by the injectors. The names of the items for the Pod
+// are the fields of the Pod, which were changed by the injectors, e.g.
+// "labels" or "tolerations". The labels contain the keys of the labels, which
+// were added to the Pod by the injectors, so that the selectors of the
+// injectors can be evaluated against the original labels of the Pod.
+type record struct {
+	Revision       string       `json:"revision"`
+	InitContainers []recordItem `json:"initContainers,omitempty"`
+	Containers     []recordItem `json:"containers,omitempty"`
+	Volumes        []recordItem `json:"volumes,omitempty"`
+	AppContainers  []recordItem `json:"appContainers,omitempty"`
+	Pod            []recordItem `json:"pod,omitempty"`
+	Labels         []string     `json:"labels,omitempty"`
+}
+
+// recordItem is an injected init container, container or volume. The
+// injectors contain the names of the injectors, which caused the injection.
+// Resources which are defined via the annotations of the Pod have the injector
+// "annotations".
+type recordItem struct {
+	Name      string   `json:"name"`
+	Injectors []string `json:"injectors"`
+}
+
+// getRecord returns the record of the given Pod. If the Pod doesn't have a
+// record, e.g. because it was injected by an older version of the sidecar
+// injector, nil is returned.
+func getRecord(pod *corev1.Pod) (*record, error) {
+	val, ok := pod.Annotations[annotationRecordKey]
+	if !ok {
+		return nil, nil
+	}
+
+	rec := &record{}
+	if err := json.Unmarshal([]byte(val), rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// hasInitContainer, hasContainer and hasVolume return true, when the
+// resource with the given name is contained in the record.
+func (r *record) hasInitContainer(name string) bool {
+	return containsItem(r.InitContainers, name)
+}
+
+func (r *record) hasContainer(name string) bool {
+	return containsItem(r.Containers, name)
+}
+
+func (r *record) hasVolume(name string) bool {
+	return containsItem(r.Volumes, name)
+}
+
+func containsItem(items []recordItem, name string) bool {
+	return slices.ContainsFunc(items, func(item recordItem) bool { return item.Name == name })
+}
+
+// String returns the JSON representation of the record, which is used as
+// value for the annotation.
+func (r *record) String() string {
+	// The marshaling can not fail, because the record only contains strings.
+	raw, _ := json.Marshal(r)
+	return string(raw)
+}
@@ -0,0 +1,89 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Record", func() {
+	Context("Recording injected resources", func() {
+		injector := &Injector{
+			Config: &Config{
+				Injectors: []InjectorData{
+					{
+						Name:       "logging",
+						Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "record"}},
+						Containers: []string{"log-shipper", "proxy"},
+						Volumes:    []string{"logs"},
+					},
+					{
+						Name:       "proxy",
+						Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "record"}},
+						Containers: []string{"proxy"},
+					},
+				},
+				Containers: []Container{
+					{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper"}},
+					{Container: corev1.Container{Name: "proxy", Image: "proxy", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)}},
+					{Container: corev1.Container{Name: "setup", Image: "setup"}},
+					{Container: corev1.Container{Name: "app", Image: "app"}, OnConflict: ConflictStrategySkip},
+				},
+				Volumes: []Volume{
+					{Volume: corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+				},
+			},
+			Decoder:        admission.NewDecoder(scheme.Scheme),
+			NativeSidecars: true,
+		}
+
+		It("Should record all injected resources with their injectors", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "record",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "record"},
+					Annotations: map[string]string{
+						annotationInitContainersKey: "setup",
+						annotationContainersKey:     "app",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+
+			injectedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+
+			rec, err := getRecord(injectedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rec.Revision).To(Equal(revision(injector.Config, &resources{
+				initContainers: []string{"setup"},
+				containers:     []string{"log-shipper", "proxy", "app"},
+				volumes:        []string{"logs"},
+			})))
+			Expect(rec.InitContainers).To(Equal([]recordItem{
+				{Name: "setup", Injectors: []string{originAnnotations}},
+				{Name: "proxy", Injectors: []string{"logging", "proxy"}},
+			}))
+			Expect(rec.Containers).To(Equal([]recordItem{
+				{Name: "log-shipper", Injectors: []string{"logging"}},
+			}))
+			Expect(rec.Volumes).To(Equal([]recordItem{
+				{Name: "logs", Injectors: []string{"logging"}},
+			}))
+		})
+
+		It("Should return nil for Pods without a record", func() {
+			rec, err := getRecord(&corev1.Pod{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rec).To(BeNil())
+		})
+	})
+})
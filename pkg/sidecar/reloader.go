@@ -0,0 +1,185 @@
+package sidecar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigReloader watches the configuration file and reloads the configuration
+// when the file is changed. The current configuration is stored in an atomic
+// pointer, so that concurrent requests always see a consistent configuration.
+//
+// Instead of the file itself we watch the directory of the file, so that we
+// also get notified when Kubernetes updates a mounted ConfigMap by swapping
+// the "..data" symlink.
+type ConfigReloader struct {
+	file    string
+	config  atomic.Pointer[Config]
+	content []byte
+
+	mu      sync.RWMutex
+	lastErr error
+}
+
+// NewConfigReloader loads the configuration from the given file and returns a
+// ConfigReloader for it. The initial configuration must be valid, otherwise an
+// error is returned.
+func NewConfigReloader(file string) (*ConfigReloader, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := parseConfig(content)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ConfigReloader{file: file, content: content}
+	r.config.Store(cfg)
+	configReloadsTotal.WithLabelValues("success")
+	configReloadsTotal.WithLabelValues("failure")
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	configGeneration.Set(1)
+
+	return r, nil
+}
+
+// Config returns the current configuration.
+func (r *ConfigReloader) Config() *Config {
+	return r.config.Load()
+}
+
+// Check can be used as readiness check. It returns the error of the last
+// reload if it failed. In this case the webhook still uses the last valid
+// configuration.
+func (r *ConfigReloader) Check(_ *http.Request) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.lastErr != nil {
+		return fmt.Errorf("failed to reload configuration: %w", r.lastErr)
+	}
+	return nil
+}
+
+// Start watches the directory of the configuration file until the given
+// context is canceled. It implements the "manager.Runnable" interface, so that
+// the ConfigReloader can be added to the manager.
+func (r *ConfigReloader) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(r.file)); err != nil {
+		return err
+	}
+
+	log.Info("Watching configuration file.", "file", r.file)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			r.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "Failed to watch configuration file.", "file", r.file)
+		}
+	}
+}
+
+// NeedLeaderElection implements the "manager.LeaderElectionRunnable"
+// interface. The configuration must be reloaded in all replicas of the
+// webhook, so that leader election is not required.
+func (r *ConfigReloader) NeedLeaderElection() bool {
+	return false
+}
+
+// reload reads the configuration file and replaces the current configuration
+// if the content of the file was changed. If the new configuration is invalid
+// the current configuration is kept and the error is reported via the logs,
+// the metrics and the readiness check. If the content of the file is the same
+// as the content of the current configuration, e.g. because an invalid change
+// was reverted, the error of the last reload is cleared.
+func (r *ConfigReloader) reload() {
+	content, err := os.ReadFile(r.file)
+	if err != nil {
+		// The file can be missing for a short period of time, when it is
+		// replaced. We will get another event when the file is created again.
+		if os.IsNotExist(err) {
+			return
+		}
+		r.setResult(err)
+		return
+	}
+
+	if bytes.Equal(content, r.content) {
+		r.clearError()
+		return
+	}
+
+	cfg, err := parseConfig(content)
+	if err != nil {
+		r.setResult(err)
+		return
+	}
+
+	r.content = content
+	r.config.Store(cfg)
+	r.setResult(nil)
+}
+
+// clearError clears the error of the last reload, when the current
+// configuration is active again.
+func (r *ConfigReloader) clearError() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lastErr == nil {
+		return
+	}
+
+	log.Info("Configuration file contains the current configuration again.", "file", r.file)
+	r.lastErr = nil
+	configLastReloadSuccessful.Set(1)
+}
+
+func (r *ConfigReloader) setResult(err error) {
+	r.mu.Lock()
+	r.lastErr = err
+	r.mu.Unlock()
+
+	if err != nil {
+		log.Error(err, "Failed to reload configuration, keeping last valid configuration.", "file", r.file)
+		configReloadsTotal.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		return
+	}
+
+	log.Info("Configuration reloaded.", "file", r.file)
+	configReloadsTotal.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	configGeneration.Inc()
+}
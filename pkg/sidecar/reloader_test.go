@@ -0,0 +1,82 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	Context("Reloading the configuration file", func() {
+		// updateConfigMap writes the given content to a new data directory and
+		// atomically swaps the "..data" symlink, like Kubernetes does it for a
+		// mounted ConfigMap.
+		updateConfigMap := func(dir, version, content string) {
+			dataDir := filepath.Join(dir, version)
+			Expect(os.Mkdir(dataDir, 0o755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dataDir, "config.yaml"), []byte(content), 0o600)).To(Succeed())
+			Expect(os.Symlink(version, filepath.Join(dir, "..data_tmp"))).To(Succeed())
+			Expect(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).To(Succeed())
+		}
+
+		It("Should reload valid configurations and keep the last valid configuration", func() {
+			dir := GinkgoT().TempDir()
+			updateConfigMap(dir, "..v1", "containers:\n  - name: container-v1\n    image: image-v1\n")
+			Expect(os.Symlink(filepath.Join("..data", "config.yaml"), filepath.Join(dir, "config.yaml"))).To(Succeed())
+
+			reloader, err := NewConfigReloader(filepath.Join(dir, "config.yaml"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reloader.Config().Containers[0].Name).To(Equal("container-v1"))
+			Expect(reloader.Check(nil)).To(Succeed())
+
+			reloaderCtx, reloaderCancel := context.WithCancel(context.Background())
+			defer reloaderCancel()
+			go func() {
+				defer GinkgoRecover()
+				Expect(reloader.Start(reloaderCtx)).To(Succeed())
+			}()
+
+			By("Update configuration")
+			attempt := 0
+			Eventually(func() string {
+				// The watcher might not be started yet, so that we have to
+				// update the configuration until the change is detected.
+				attempt++
+				updateConfigMap(dir, fmt.Sprintf("..v2-%d", attempt), "containers:\n  - name: container-v2\n    image: image-v2\n")
+				return reloader.Config().Containers[0].Name
+			}).Should(Equal("container-v2"))
+			Expect(reloader.Check(nil)).To(Succeed())
+
+			By("Update configuration with invalid content")
+			updateConfigMap(dir, "..v3", "injectors:\n  - selector:\n      matchExpressions:\n        - key: app\n          operator: Invalid\n")
+			Eventually(func() error { return reloader.Check(nil) }).Should(HaveOccurred())
+			Expect(reloader.Config().Containers[0].Name).To(Equal("container-v2"))
+
+			By("Restore last valid configuration")
+			updateConfigMap(dir, "..v3-restored", "containers:\n  - name: container-v2\n    image: image-v2\n")
+			Eventually(func() error { return reloader.Check(nil) }).Should(Succeed())
+			Expect(reloader.Config().Containers[0].Name).To(Equal("container-v2"))
+
+			By("Update configuration with invalid content again")
+			updateConfigMap(dir, "..v3-invalid", "injectors:\n  - selector:\n      matchExpressions:\n        - key: app\n          operator: Invalid\n")
+			Eventually(func() error { return reloader.Check(nil) }).Should(HaveOccurred())
+
+			By("Fix configuration")
+			updateConfigMap(dir, "..v4", "containers:\n  - name: container-v4\n    image: image-v4\n")
+			Eventually(func() error { return reloader.Check(nil) }).Should(Succeed())
+			Expect(reloader.Config().Containers[0].Name).To(Equal("container-v4"))
+		})
+
+		It("Should fail when initial configuration is invalid", func() {
+			file := filepath.Join(GinkgoT().TempDir(), "config.yaml")
+			Expect(os.WriteFile(file, []byte("injectors: invalid"), 0o600)).To(Succeed())
+
+			_, err := NewConfigReloader(file)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package sidecar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// revision returns a hash of the definitions of the init containers,
+// containers and volumes, which are injected into a Pod, and of the mutations
+// for the existing containers and the Pod. The hash is computed from the definitions
+// before the templates are rendered, so that the same definitions always
+// result in the same revision. If no resources are injected, the revision is
+// empty.
+func revision(cfg *Config, res *resources) string {
+	if res == nil {
+		return ""
+	}
+
+	data := struct {
+		InitContainers       []Container            `json:"initContainers,omitempty"`
+		Containers           []Container            `json:"containers,omitempty"`
+		NativeSidecars       []string               `json:"nativeSidecars,omitempty"`
+		Volumes              []Volume               `json:"volumes,omitempty"`
+		EnvironmentVariables []EnvironmentVariable  `json:"environmentVariables,omitempty"`
+		AppContainers        []AppContainerMutation `json:"appContainers,omitempty"`
+		Pod                  []PodMutation          `json:"pod,omitempty"`
+	}{
+		NativeSidecars: res.nativeSidecars,
+	}
+
+	idx, err := cfg.getIndex()
+	if err != nil {
+		return ""
+	}
+
+	for _, name := range res.initContainers {
+		if container, err := idx.container(name); err == nil {
+			data.InitContainers = append(data.InitContainers, container)
+		}
+	}
+	for _, name := range res.containers {
+		if container, err := idx.container(name); err == nil {
+			data.Containers = append(data.Containers, container)
+		}
+	}
+	for _, name := range res.volumes {
+		if volume, err := idx.volume(name); err == nil {
+			data.Volumes = append(data.Volumes, volume)
+		}
+	}
+	for _, name := range append(append([]string{}, res.initContainers...), res.containers...) {
+		data.EnvironmentVariables = append(data.EnvironmentVariables, idx.environmentVariables[name]...)
+	}
+
+	for _, m := range res.appContainers {
+		data.AppContainers = append(data.AppContainers, m.mutation)
+	}
+	for _, m := range res.podMutations {
+		data.Pod = append(data.Pod, m.mutation)
+	}
+
+	// The marshaling can not fail, because the data only contains types which
+	// can be marshaled.
+	raw, _ := json.Marshal(data)
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])[:16]
+}
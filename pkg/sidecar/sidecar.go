@@ -2,15 +2,22 @@ package sidecar
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -22,6 +29,10 @@ const (
 	annotationInitContainersKey = "sidecar-injector.ricoberger.de/init-containers"
 	annotationVolumesKey        = "sidecar-injector.ricoberger.de/volumes"
 	annotationStatusKey         = "sidecar-injector.ricoberger.de/status"
+	annotationInjectorsKey      = "sidecar-injector.ricoberger.de/injectors"
+	annotationRecordKey         = "sidecar-injector.ricoberger.de/record"
+
+	annotationExcludeContainersKey = "sidecar-injector.ricoberger.de/exclude-containers"
 )
 
 var (
@@ -32,36 +43,354 @@ type Injector struct {
 	Client  client.Client
 	Config  *Config
 	Decoder admission.Decoder
+
+	// Reloader is used to get the current configuration, when the
+	// configuration file should be reloaded on changes. If the Reloader is
+	// nil, the static Config is used.
+	Reloader *ConfigReloader
+
+	// CustomResources enables the usage of the SidecarTemplate and
+	// SidecarInjector custom resources. If enabled the resources are read via
+	// the Client and merged with the Config for each request.
+	CustomResources bool
+
+	// NativeSidecars defines if the Kubernetes cluster supports native sidecar
+	// containers. If it is false, containers which should be injected as
+	// native sidecar containers are injected as regular containers.
+	NativeSidecars bool
+
+	// Events is used to emit Kubernetes Events for the workloads of the Pods,
+	// which describe what was injected or why the injection failed. If it is
+	// nil, no Events are emitted.
+	Events *EventRecorder
+
+	// namespaces contains the Namespaces, which are used for the namespace
+	// selectors of the injectors, when the Client is nil.
+	namespaces map[string]*corev1.Namespace
+
+	// dryRun disables the metrics for the injected resources, so that
+	// explanations do not change the metrics.
+	dryRun bool
+}
+
+// getConfig returns the configuration which should be used for a request. If
+// the custom resources are enabled, all valid SidecarTemplates and
+// SidecarInjectors are merged into the configuration.
+func (i *Injector) getConfig(ctx context.Context) (*Config, error) {
+	cfg := i.Config
+	if i.Reloader != nil {
+		cfg = i.Reloader.Config()
+	}
+
+	// A configuration which was not loaded from a file, e.g. because it was
+	// created in the code, is compiled for each request. We compile a copy,
+	// so that the shared configuration is not changed.
+	if cfg.index == nil {
+		compiled := *cfg
+		if err := compiled.compile(); err != nil {
+			return nil, err
+		}
+		cfg = &compiled
+	}
+
+	if !i.CustomResources {
+		return cfg, nil
+	}
+
+	templates := &v1alpha1.SidecarTemplateList{}
+	if err := i.Client.List(ctx, templates); err != nil {
+		return nil, err
+	}
+
+	injectors := &v1alpha1.SidecarInjectorList{}
+	if err := i.Client.List(ctx, injectors); err != nil {
+		return nil, err
+	}
+
+	return cfg.withCustomResources(templates.Items, injectors.Items)
+}
+
+// resources contains the names of the init containers, containers and volumes
+// which should be injected into a Pod and the names of the injectors which
+// matched the Pod. The nativeSidecars contain the names of the containers,
+// which should be injected as native sidecar containers.
+//
+// The appContainers contain the mutations for the existing containers of the
+// Pod and the podMutations contain the mutations for the metadata and the
+// specification of the Pod, which are defined by the matched injectors.
+//
+// The origins contain the names of the injectors, which caused the injection
+// of an init container, container or volume. Resources which are defined via
+// the annotations of the Pod have the origin "annotations".
+type resources struct {
+	injectors      []string
+	initContainers []string
+	containers     []string
+	nativeSidecars []string
+	volumes        []string
+	appContainers  []appContainerMutation
+	podMutations   []podMutation
+	origins        map[resourceKey][]string
+
+	// revision is the revision of the resources, see revision. It is computed
+	// by getResourcesToInject, before the volumes which are required by the
+	// injected containers are added, so that it only depends on the
+	// configuration and on the labels and annotations of the Pod.
+	revision string
 }
 
-func (i *Injector) getResourcesToInject(req admission.Request, pod *corev1.Pod) ([]string, []string, []string, bool, error) {
-	var initContainers []string
-	var containers []string
-	var volumes []string
+// resourceKey identifies an injected init container, container or volume.
+type resourceKey struct {
+	kind string
+	name string
+}
+
+const (
+	kindInitContainer = "init_container"
+	kindContainer     = "container"
+	kindVolume        = "volume"
+
+	originAnnotations = "annotations"
+)
+
+// add adds the given names to the list of resources, names which are already
+// contained in the list are ignored. The origin is recorded for all given
+// names.
+func (r *resources) add(origin string, initContainers, containers, volumes []string) {
+	r.initContainers = appendUnique(r.initContainers, initContainers...)
+	r.containers = appendUnique(r.containers, containers...)
+	r.volumes = appendUnique(r.volumes, volumes...)
+
+	if r.origins == nil {
+		r.origins = make(map[resourceKey][]string)
+	}
+	for kind, names := range map[string][]string{kindInitContainer: initContainers, kindContainer: containers, kindVolume: volumes} {
+		for _, name := range names {
+			key := resourceKey{kind: kind, name: name}
+			r.origins[key] = appendUnique(r.origins[key], origin)
+		}
+	}
+}
+
+// origin returns the first injector, which caused the injection of the given
+// resource.
+func (r *resources) origin(kind, name string) string {
+	return r.injectorsOf(kind, name)[0]
+}
+
+// injectorsOf returns all injectors, which caused the injection of the given
+// resource.
+func (r *resources) injectorsOf(kind, name string) []string {
+	if origins := r.origins[resourceKey{kind: kind, name: name}]; len(origins) > 0 {
+		return origins
+	}
+	return []string{originAnnotations}
+}
+
+// remove removes the container with the given name from the list of init
+// containers and containers.
+func (r *resources) remove(name string) {
+	isName := func(value string) bool { return value == name }
+	r.initContainers = slices.DeleteFunc(r.initContainers, isName)
+	r.containers = slices.DeleteFunc(r.containers, isName)
+	r.nativeSidecars = slices.DeleteFunc(r.nativeSidecars, isName)
+}
+
+func (r *resources) isEmpty() bool {
+	return len(r.initContainers) == 0 && len(r.containers) == 0 && len(r.volumes) == 0 && len(r.appContainers) == 0 && len(r.podMutations) == 0
+}
+
+// addRequiredVolumes adds the volumes, which are mounted by the injected
+// containers and by the mutations of the app containers, to the list of
+// volumes. Volumes which are already contained in the Pod or in the list are
+// ignored. All other volumes must be defined in the configuration, otherwise
+// an error is returned. The origins of an added volume are the injectors of
+// the containers or mutations, which mount the volume.
+func (r *resources) addRequiredVolumes(pod *corev1.Pod, idx *configIndex) error {
+	available := func(name string) bool {
+		return slices.Contains(r.volumes, name) || slices.ContainsFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == name })
+	}
+
+	require := func(name, description string, origins []string) error {
+		if available(name) {
+			return nil
+		}
+		if _, ok := idx.volumes[name]; !ok {
+			return fmt.Errorf("volume %q of %s is defined neither in the Pod nor in the configuration", name, description)
+		}
+		for _, origin := range origins {
+			r.add(origin, nil, nil, []string{name})
+		}
+		return nil
+	}
+
+	// The init containers are checked before the containers, so that the
+	// volumes are always added in the same order.
+	for _, kind := range []string{kindInitContainer, kindContainer} {
+		names := r.initContainers
+		if kind == kindContainer {
+			names = r.containers
+		}
+
+		for _, name := range names {
+			// Containers which are not defined are reported, when they are
+			// injected.
+			container, ok := idx.containers[name]
+			if !ok {
+				continue
+			}
+
+			for _, volumeMount := range container.VolumeMounts {
+				if err := require(volumeMount.Name, fmt.Sprintf("volume mount %q in container %q", volumeMount.MountPath, name), r.injectorsOf(kind, name)); err != nil {
+					return err
+				}
+			}
+			for _, volumeDevice := range container.VolumeDevices {
+				if err := require(volumeDevice.Name, fmt.Sprintf("volume device %q in container %q", volumeDevice.DevicePath, name), r.injectorsOf(kind, name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, m := range r.appContainers {
+		for _, volumeMount := range m.mutation.VolumeMounts {
+			if err := require(volumeMount.Name, fmt.Sprintf("volume mount %q in the app containers of injector %q", volumeMount.MountPath, m.injector), []string{m.injector}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// getNamespaceLabels returns the labels of the given Namespace. The Namespace
+// is read via the Client, which uses the cache of the manager. If the Client is
+// nil, e.g. when manifests are injected offline, the Namespace is taken from
+// the Namespaces of the manifests.
+func (i *Injector) getNamespaceLabels(ctx context.Context, name string) (labels.Set, error) {
+	namespace, err := i.getNamespace(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// We always return a non-nil set, so that the caller can check if the
+	// labels were already fetched.
+	namespaceLabels := labels.Set{}
+	for key, value := range namespace.Labels {
+		namespaceLabels[key] = value
+	}
+
+	return namespaceLabels, nil
+}
+
+// getNamespace returns the Namespace with the given name. If the Client is nil
+// and the Namespace is unknown, an empty Namespace is returned.
+func (i *Injector) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	namespace := &corev1.Namespace{}
+	if i.Client == nil {
+		if ns, ok := i.namespaces[name]; ok {
+			namespace = ns
+		}
+	} else if err := i.Client.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return nil, err
+	}
+
+	return namespace, nil
+}
+
+// getResourcesToInject returns the resources, which should be injected into
+// the given Pod. If no resources should be injected, the returned resources
+// are nil and the returned string contains the reason.
+func (i *Injector) getResourcesToInject(ctx context.Context, req admission.Request, pod *corev1.Pod, cfg *Config) (*resources, string, error) {
+	res := &resources{}
 
 	// If the Pod already has the annotation
 	// `sidecar-injector.ricoberger.de/status` set to `injected` we can skip the
 	// injection of resources, because this was already done.
 	if val, ok := pod.Annotations[annotationStatusKey]; ok && val == "injected" {
 		log.Info("Already injected.", "name", req.Name, "namespace", req.Namespace)
-		return nil, nil, nil, false, nil
+		return nil, reasonAlreadyInjected, nil
+	}
+
+	idx, err := cfg.getIndex()
+	if err != nil {
+		log.Error(err, "Failed to compile configuration.", "name", req.Name, "namespace", req.Namespace)
+		return nil, "", err
 	}
 
 	// Check if the Pod matches an defined injector, by comparing the labels of
-	// the Pod with the defined selector of the injector definition. If the Pod
-	// matches the selector we add the defined resources in the injector to the
-	// list of resources which should be injected.
-	for _, injector := range i.Config.Injectors {
-		selector, err := metav1.LabelSelectorAsSelector(&injector.Selector)
-		if err != nil {
-			log.Error(err, "Failed to convert label selector to selector.", "name", req.Name, "namespace", req.Namespace)
-			return nil, nil, nil, false, err
+	// the Pod with the defined selector of the injector definition.
+	var matchedInjectors []compiledInjector
+	var namespaceLabels labels.Set
+
+	for _, injector := range idx.injectors {
+		if !injector.selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		// If the injector has a namespace selector, the labels of the Namespace
+		// of the Pod must also match the namespace selector. The Namespace is
+		// only fetched once per request and only when it is required.
+		if injector.namespaceSelector != nil {
+			if namespaceLabels == nil {
+				namespaceLabels, err = i.getNamespaceLabels(ctx, req.Namespace)
+				if err != nil {
+					log.Error(err, "Failed to get namespace.", "name", req.Name, "namespace", req.Namespace)
+					return nil, "", err
+				}
+			}
+
+			if !injector.namespaceSelector.Matches(namespaceLabels) {
+				continue
+			}
+		}
+
+		matchedInjectors = append(matchedInjectors, injector)
+	}
+
+	// The matched injectors are sorted by their priority, so that the resources
+	// of injectors with a higher priority are injected first. If one of the
+	// matched injectors is exclusive, only the exclusive injector with the
+	// highest priority is applied.
+	sort.SliceStable(matchedInjectors, func(a, b int) bool {
+		return matchedInjectors[a].injector.Priority > matchedInjectors[b].injector.Priority
+	})
+
+	for _, matched := range matchedInjectors {
+		if matched.injector.Exclusive {
+			matchedInjectors = []compiledInjector{matched}
+			break
+		}
+	}
+
+	// Pods can opt out of the injection via the
+	// `sidecar-injector.ricoberger.de: disabled` annotation. This is not
+	// allowed, when one of the matched injectors is mandatory.
+	if val, ok := pod.Annotations[annotationInjectKey]; ok && val == "disabled" {
+		for _, matched := range matchedInjectors {
+			if matched.injector.Mandatory {
+				return nil, "", fmt.Errorf("injector %q is mandatory and can not be disabled via the %q annotation", matched.name, annotationInjectKey)
+			}
 		}
 
-		if selector.Matches(labels.Set(pod.Labels)) {
-			initContainers = injector.InitContainers
-			containers = injector.Containers
-			volumes = injector.Volumes
+		log.Info("Injection disabled.", "name", req.Name, "namespace", req.Namespace)
+		return nil, reasonDisabled, nil
+	}
+
+	// Merge the resources of all matched injectors. If multiple injectors
+	// define the same resource, the resource is only injected once.
+	for _, matched := range matchedInjectors {
+		res.injectors = append(res.injectors, matched.name)
+		res.add(matched.name, matched.injector.InitContainers, matched.injector.Containers, matched.injector.Volumes)
+		if matched.injector.NativeSidecars {
+			res.nativeSidecars = appendUnique(res.nativeSidecars, matched.injector.Containers...)
+		}
+		for index, mutation := range matched.injector.AppContainers {
+			res.appContainers = append(res.appContainers, appContainerMutation{injector: matched.name, index: index, mutation: mutation})
+		}
+		if matched.injector.Pod != nil {
+			res.podMutations = append(res.podMutations, podMutation{injector: matched.name, mutation: *matched.injector.Pod})
 		}
 	}
 
@@ -71,173 +400,571 @@ func (i *Injector) getResourcesToInject(req admission.Request, pod *corev1.Pod)
 	//
 	// If the Pod doesn't have the label and didn't matched any of the defined
 	// injectors from the config, we can skip the injection of sidecars.
-	if val, ok := pod.Annotations[annotationInjectKey]; (!ok || val != "enabled") && (len(initContainers) == 0 && len(containers) == 0 && len(volumes) == 0) {
+	if val, ok := pod.Annotations[annotationInjectKey]; (!ok || val != "enabled") && res.isEmpty() {
 		log.Info("No injection required.", "name", req.Name, "namespace", req.Namespace)
-		return nil, nil, nil, false, nil
+		return nil, reasonNoMatch, nil
 	}
 
 	// Check the sidecar injector annotations of the Pod and add the defined
 	// Init Containers, Containers and Volumes to the lists of resources, which
 	// should be injected into the Pod.
+	var initContainers, containers, volumes []string
+
 	if initContainerNames, ok := pod.Annotations[annotationInitContainersKey]; ok && initContainerNames != "" {
-		initContainers = append(initContainers, strings.Split(initContainerNames, ",")...)
+		initContainers = strings.Split(initContainerNames, ",")
 	}
 
 	if containerNames, ok := pod.Annotations[annotationContainersKey]; ok && containerNames != "" {
-		containers = append(containers, strings.Split(containerNames, ",")...)
+		containers = strings.Split(containerNames, ",")
 	}
 
 	if volumeNames, ok := pod.Annotations[annotationVolumesKey]; ok && volumeNames != "" {
-		volumes = append(volumes, strings.Split(volumeNames, ",")...)
+		volumes = strings.Split(volumeNames, ",")
 	}
 
-	return initContainers, containers, volumes, true, nil
+	res.add(originAnnotations, initContainers, containers, volumes)
+
+	// Single containers can be excluded from the injection via the
+	// `sidecar-injector.ricoberger.de/exclude-containers` annotation, unless
+	// they are injected by a mandatory injector.
+	if excludedContainerNames, ok := pod.Annotations[annotationExcludeContainersKey]; ok && excludedContainerNames != "" {
+		for _, name := range strings.Split(excludedContainerNames, ",") {
+			for _, matched := range matchedInjectors {
+				if matched.injector.Mandatory && (slices.Contains(matched.injector.Containers, name) || slices.Contains(matched.injector.InitContainers, name)) {
+					return nil, "", fmt.Errorf("container %q of the mandatory injector %q can not be excluded via the %q annotation", name, matched.name, annotationExcludeContainersKey)
+				}
+			}
+			res.remove(name)
+		}
+
+		if res.isEmpty() {
+			log.Info("No injection required.", "name", req.Name, "namespace", req.Namespace)
+			return nil, reasonExcluded, nil
+		}
+	}
+
+	res.revision = revision(cfg, res)
+	return res, "", nil
 }
 
+// Handle handles an admission request for a Pod and records the outcome of
+// the request and the latency of the handler in the metrics.
 func (i *Injector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	start := time.Now()
+	defer func() {
+		admissionDurationSeconds.WithLabelValues(string(req.Operation)).Observe(time.Since(start).Seconds())
+	}()
+
 	pod := &corev1.Pod{}
 
 	err := i.Decoder.Decode(req, pod)
 	if err != nil {
 		log.Error(err, "Could not decode request.", "name", req.Name, "namespace", req.Namespace)
+		admissionRequestsTotal.WithLabelValues(string(req.Operation), outcomeErrored, "decode").Inc()
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	initContainers, containers, volumes, inject, err := i.getResourcesToInject(req, pod)
+	response, outcome, reason := i.mutate(ctx, req, pod)
+	admissionRequestsTotal.WithLabelValues(string(req.Operation), outcome, reason).Inc()
+
+	// Failed injections are reported via an Event for the workload of the
+	// Pod, so that they are visible without access to the logs of the
+	// webhook.
+	if (outcome == outcomeErrored || outcome == outcomeDenied) && response.Result != nil {
+		i.Events.Eventf(pod, req.Namespace, corev1.EventTypeWarning, eventReasonInjectionFailed, "Injection failed: %s", response.Result.Message)
+	}
+
+	return response
+}
+
+// mutate injects the resources into the given Pod from the admission request.
+// Besides the admission response it returns the outcome and the reason of the
+// outcome, which are used as labels of the metrics.
+func (i *Injector) mutate(ctx context.Context, req admission.Request, pod *corev1.Pod) (admission.Response, string, string) {
+	cfg, err := i.getConfig(ctx)
+	if err != nil {
+		log.Error(err, "Could not get configuration.", "name", req.Name, "namespace", req.Namespace)
+		return admission.Errored(http.StatusInternalServerError, err), outcomeErrored, "config"
+	}
+
+	// Containers can not be added to an existing Pod, so that we only verify
+	// on updates that the injected resources were not changed.
+	if req.Operation == admissionv1.Update {
+		return i.handleUpdate(req, pod, cfg)
+	}
+
+	res, reason, err := i.getResourcesToInject(ctx, req, pod, cfg)
 	if err != nil {
 		log.Error(err, "Failed to get resources to inject.", "name", req.Name, "namespace", req.Namespace)
-		return admission.Errored(http.StatusBadRequest, err)
+		return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "resources"
+	}
+	if res == nil {
+		if reason == reasonAlreadyInjected {
+			return admission.Allowed("No injection required."), outcomeAlreadyInjected, reason
+		}
+		return admission.Allowed("No injection required."), outcomeSkipped, reason
+	}
+
+	// The index can not fail, because it was already used to get the
+	// resources, which should be injected.
+	idx, err := cfg.getIndex()
+	if err != nil {
+		log.Error(err, "Failed to compile configuration.", "name", req.Name, "namespace", req.Namespace)
+		return admission.Errored(http.StatusInternalServerError, err), outcomeErrored, "config"
+	}
+
+	// Init containers, containers and volumes from the annotations of the Pod,
+	// which are not defined in the configuration, are rejected, so that a Pod
+	// is never created without a requested sidecar.
+	if err := res.checkDefined(idx); err != nil {
+		log.Error(err, "Undefined resources.", "name", req.Name, "namespace", req.Namespace)
+		return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "not-defined"
+	}
+
+	// The volumes which are mounted by the injected containers are added to
+	// the resources, so that they do not have to be listed separately. They
+	// are added before the annotations are checked, so that the parameters of
+	// these volumes are not reported as unused.
+	if err := res.addRequiredVolumes(pod, idx); err != nil {
+		log.Error(err, "Failed to get required volumes.", "name", req.Name, "namespace", req.Namespace)
+		return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "volume-not-found"
+	}
+
+	// Annotations which are ignored or can not be parsed are returned as
+	// warnings, so that the user is informed that the annotation didn't have
+	// an effect. In strict mode the Pod is denied instead.
+	annotationWarnings := checkAnnotations(pod.Annotations, cfg, idx, res)
+
+	// The annotations of the Namespace contain the defaults for the resources
+	// of the injected containers. Like for the namespace selectors of the
+	// injectors, the Pod is rejected, when the Namespace can not be read, so
+	// that the defaults are never silently ignored. Annotations of the
+	// Namespace, which can not be parsed, are always returned as warnings,
+	// also in strict mode, because they can not be fixed in the Pod.
+	var namespaceAnnotations map[string]string
+	var namespaceWarnings []string
+	if len(res.initContainers) > 0 || len(res.containers) > 0 {
+		namespace, err := i.getNamespace(ctx, req.Namespace)
+		if err != nil {
+			log.Error(err, "Failed to get namespace.", "name", req.Name, "namespace", req.Namespace)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "resources"
+		}
+		namespaceAnnotations = namespace.Annotations
+	}
+
+	// The container and volume definitions can contain templates, which are
+	// rendered with the metadata of the Pod. The template data must be created
+	// before we inject any containers, so that only the containers of the
+	// application are available in the templates.
+	data := newTemplateData(pod, req.Namespace)
+
+	// The warnings contain the decisions for conflicting containers and
+	// volumes, which are returned to the user in the admission response. The
+	// injected resources are used to update the metrics, after the Pod was
+	// patched successfully. The record contains the injected resources with
+	// their placement in the Pod and is added as annotation to the Pod. The
+	// patch contains the operations for all changes of the Pod.
+	var warnings []string
+	var injected []resourceKey
+	rec := &record{Revision: res.revision}
+	patch := &podPatch{}
+
+	// The existing containers of the Pod are mutated before any containers
+	// are injected, so that the mutations are only applied to the containers
+	// of the application.
+	appContainers := make(map[string][]string)
+	for _, m := range res.appContainers {
+		mutation := m.mutation.deepCopy()
+		if mutation.Templates {
+			if err := renderTemplates(&mutation, data, field.NewPath("injectors").Key(m.injector).Child("appContainers").Index(m.index), idx.templates); err != nil {
+				log.Error(err, "Failed to render app container template.", "name", req.Name, "namespace", req.Namespace, "injector", m.injector)
+				return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+			}
+		}
+
+		for index, container := range pod.Spec.Containers {
+			if !mutation.matches(container.Name) {
+				continue
+			}
+
+			ok, mutationWarnings, err := mutateAppContainer(pod, patch, index, mutation, cfg.conflictStrategy(mutation.OnConflict))
+			if err != nil {
+				log.Error(err, "Failed to mutate app container.", "name", req.Name, "namespace", req.Namespace, "container", container.Name)
+				return admission.Denied(err.Error()), outcomeDenied, "conflict"
+			}
+			warnings = append(warnings, mutationWarnings...)
+			if ok {
+				if _, recorded := appContainers[container.Name]; !recorded {
+					rec.AppContainers = append(rec.AppContainers, recordItem{Name: container.Name})
+				}
+				appContainers[container.Name] = appendUnique(appContainers[container.Name], m.injector)
+			}
+		}
 	}
-	if !inject {
-		return admission.Allowed("No injection required.")
+	for index := range rec.AppContainers {
+		rec.AppContainers[index].Injectors = appContainers[rec.AppContainers[index].Name]
 	}
 
-	for _, initContainerName := range initContainers {
-		container, err := getContainer(initContainerName, i.Config.Containers)
+	for _, initContainerName := range res.initContainers {
+		definition, err := idx.container(initContainerName)
 		if err != nil {
 			log.Error(err, "Init-Container was not found.", "name", req.Name, "namespace", req.Namespace, "init-container", initContainerName)
-			return admission.Errored(http.StatusBadRequest, err)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "container-not-found"
+		}
+
+		container := definition.Container
+		if definition.Templates {
+			if err := renderTemplates(&container, data, field.NewPath("containers").Key(initContainerName), idx.templates); err != nil {
+				log.Error(err, "Failed to render init-container template.", "name", req.Name, "namespace", req.Namespace, "init-container", initContainerName)
+				return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+			}
+		}
+
+		container, err = addEnvVariables(container, data, idx.environmentVariables[container.Name], idx.templates)
+		if err != nil {
+			log.Error(err, "Failed to add environment variables.", "name", req.Name, "namespace", req.Namespace, "container", container.Name)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+		}
+		container, messages := setResources(container, annotationInitContainersKey, namespaceAnnotations, pod.Annotations, definition.ResourceBounds)
+		annotationWarnings = append(annotationWarnings, messages.invalid...)
+		namespaceWarnings = append(namespaceWarnings, messages.namespace...)
+		warnings = append(warnings, messages.clamped...)
+
+		// Init containers with the restart policy "Always" are native sidecar
+		// containers. If they are not supported by the cluster, they are
+		// injected as regular containers.
+		initContainer := true
+		if isNativeSidecar(container) && !i.NativeSidecars {
+			container.RestartPolicy = nil
+			initContainer = false
 		}
 
-		container = addEnvVariables(container, pod.Annotations, i.Config.EnvironmentVariables)
-		container = setResources(container, annotationInitContainersKey, pod.Annotations)
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, container)
+		ok, warning, err := injectContainer(pod, patch, container, initContainer, cfg.conflictStrategy(definition.OnConflict))
+		if err != nil {
+			log.Error(err, "Failed to inject init-container.", "name", req.Name, "namespace", req.Namespace, "init-container", initContainerName)
+			return admission.Denied(err.Error()), outcomeDenied, "conflict"
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if ok {
+			injected = append(injected, resourceKey{kind: kindInitContainer, name: initContainerName})
+			item := recordItem{Name: initContainerName, Injectors: res.injectorsOf(kindInitContainer, initContainerName)}
+			if initContainer {
+				rec.InitContainers = append(rec.InitContainers, item)
+			} else {
+				rec.Containers = append(rec.Containers, item)
+			}
+		}
 	}
 
-	for _, containerName := range containers {
-		container, err := getContainer(containerName, i.Config.Containers)
+	for _, containerName := range res.containers {
+		definition, err := idx.container(containerName)
 		if err != nil {
 			log.Error(err, "Container was not found.", "name", req.Name, "namespace", req.Namespace, "container", containerName)
-			return admission.Errored(http.StatusBadRequest, err)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "container-not-found"
 		}
 
-		container = addEnvVariables(container, pod.Annotations, i.Config.EnvironmentVariables)
-		container = setResources(container, annotationContainersKey, pod.Annotations)
-		pod.Spec.Containers = append(pod.Spec.Containers, container)
+		container := definition.Container
+		if definition.Templates {
+			if err := renderTemplates(&container, data, field.NewPath("containers").Key(containerName), idx.templates); err != nil {
+				log.Error(err, "Failed to render container template.", "name", req.Name, "namespace", req.Namespace, "container", containerName)
+				return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+			}
+		}
+
+		container, err = addEnvVariables(container, data, idx.environmentVariables[container.Name], idx.templates)
+		if err != nil {
+			log.Error(err, "Failed to add environment variables.", "name", req.Name, "namespace", req.Namespace, "container", container.Name)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+		}
+		container, messages := setResources(container, annotationContainersKey, namespaceAnnotations, pod.Annotations, definition.ResourceBounds)
+		annotationWarnings = append(annotationWarnings, messages.invalid...)
+		namespaceWarnings = append(namespaceWarnings, messages.namespace...)
+		warnings = append(warnings, messages.clamped...)
+
+		// Containers are injected as native sidecar containers, when the
+		// injector or the container definition requires it and the cluster
+		// supports native sidecar containers. Otherwise the restart policy is
+		// removed, because it is not allowed for regular containers.
+		initContainer := false
+		if (slices.Contains(res.nativeSidecars, containerName) || isNativeSidecar(container)) && i.NativeSidecars {
+			container.RestartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+			initContainer = true
+		} else {
+			container.RestartPolicy = nil
+		}
+
+		ok, warning, err := injectContainer(pod, patch, container, initContainer, cfg.conflictStrategy(definition.OnConflict))
+		if err != nil {
+			log.Error(err, "Failed to inject container.", "name", req.Name, "namespace", req.Namespace, "container", containerName)
+			return admission.Denied(err.Error()), outcomeDenied, "conflict"
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if ok {
+			injected = append(injected, resourceKey{kind: kindContainer, name: containerName})
+			item := recordItem{Name: containerName, Injectors: res.injectorsOf(kindContainer, containerName)}
+			if initContainer {
+				rec.InitContainers = append(rec.InitContainers, item)
+			} else {
+				rec.Containers = append(rec.Containers, item)
+			}
+		}
 	}
 
-	for _, volumeName := range volumes {
-		volume, err := getVolume(volumeName, i.Config.Volumes)
+	for _, volumeName := range res.volumes {
+		definition, err := idx.volume(volumeName)
 		if err != nil {
 			log.Error(err, "Volume was not found.", "name", req.Name, "namespace", req.Namespace, "volume", volumeName)
-			return admission.Errored(http.StatusBadRequest, err)
+			return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "volume-not-found"
+		}
+
+		volume := definition.Volume
+		if definition.Templates {
+			if err := renderTemplates(&volume, data, field.NewPath("volumes").Key(volumeName), idx.templates); err != nil {
+				log.Error(err, "Failed to render volume template.", "name", req.Name, "namespace", req.Namespace, "volume", volumeName)
+				return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+			}
 		}
+		volume, invalid := setVolumeParameters(volume, idx.volumeParameters[volumeName], pod.Annotations)
+		annotationWarnings = append(annotationWarnings, invalid...)
 
-		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+		ok, warning, err := injectVolume(pod, patch, volume, cfg.conflictStrategy(definition.OnConflict))
+		if err != nil {
+			log.Error(err, "Failed to inject volume.", "name", req.Name, "namespace", req.Namespace, "volume", volumeName)
+			return admission.Denied(err.Error()), outcomeDenied, "conflict"
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if ok {
+			injected = append(injected, resourceKey{kind: kindVolume, name: volumeName})
+			rec.Volumes = append(rec.Volumes, recordItem{Name: volumeName, Injectors: res.injectorsOf(kindVolume, volumeName)})
+		}
 	}
 
-	if pod.Annotations == nil {
-		pod.Annotations = map[string]string{annotationStatusKey: "injected"}
-	} else {
-		pod.Annotations[annotationStatusKey] = "injected"
+	// The metadata and the specification of the Pod are mutated after all
+	// templates were rendered, so that the added labels and annotations are
+	// not available in the templates.
+	podFields := make(map[string][]string)
+	existingLabels := maps.Clone(pod.Labels)
+	for _, m := range res.podMutations {
+		mutation := m.mutation.deepCopy()
+		if mutation.Templates {
+			if err := renderTemplates(&mutation, data, field.NewPath("injectors").Key(m.injector).Child("pod"), idx.templates); err != nil {
+				log.Error(err, "Failed to render pod template.", "name", req.Name, "namespace", req.Namespace, "injector", m.injector)
+				return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "template"
+			}
+		}
+
+		changed, mutationWarnings, err := mutatePod(pod, patch, mutation, cfg.conflictStrategy(mutation.OnConflict))
+		if err != nil {
+			log.Error(err, "Failed to mutate pod.", "name", req.Name, "namespace", req.Namespace, "injector", m.injector)
+			return admission.Denied(err.Error()), outcomeDenied, "conflict"
+		}
+		warnings = append(warnings, mutationWarnings...)
+		for _, name := range changed {
+			if _, recorded := podFields[name]; !recorded {
+				rec.Pod = append(rec.Pod, recordItem{Name: name})
+			}
+			podFields[name] = appendUnique(podFields[name], m.injector)
+		}
+	}
+	for index := range rec.Pod {
+		rec.Pod[index].Injectors = podFields[rec.Pod[index].Name]
+	}
+	for _, key := range sortedKeys(pod.Labels) {
+		if _, ok := existingLabels[key]; !ok {
+			rec.Labels = append(rec.Labels, key)
+		}
 	}
 
-	marshaledPod, err := json.Marshal(pod)
-	if err != nil {
-		log.Error(err, "Could not marshal pod.", "name", req.Name, "namespace", req.Namespace)
-		return admission.Errored(http.StatusInternalServerError, err)
+	for _, message := range annotationWarnings {
+		i.Events.Eventf(pod, req.Namespace, corev1.EventTypeWarning, eventReasonInvalidAnnotation, "%s", message)
 	}
+	if len(annotationWarnings) > 0 {
+		if cfg.Strict {
+			log.Info("Invalid annotations.", "name", req.Name, "namespace", req.Namespace, "warnings", annotationWarnings)
+			return admission.Denied(fmt.Sprintf("invalid annotations: %s", strings.Join(annotationWarnings, "; "))), outcomeDenied, "invalid-annotations"
+		}
+		warnings = append(warnings, annotationWarnings...)
+	}
+	warnings = append(warnings, namespaceWarnings...)
 
-	log.Info("Inject sidecar.", "name", req.Name, "namespace", req.Namespace)
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
-}
+	annotations := map[string]string{
+		annotationStatusKey: "injected",
+		annotationRecordKey: rec.String(),
+	}
+	if len(res.injectors) > 0 {
+		annotations[annotationInjectorsKey] = strings.Join(res.injectors, ",")
+	}
+	patch.setAnnotations(pod, annotations)
 
-func getContainer(name string, containers []corev1.Container) (corev1.Container, error) {
-	for _, container := range containers {
-		if container.Name == name {
-			return *container.DeepCopy(), nil
+	var injectedNames []string
+	for _, item := range injected {
+		if !i.dryRun {
+			injectedTotal.WithLabelValues(item.kind, item.name, res.origin(item.kind, item.name)).Inc()
 		}
+		injectedNames = append(injectedNames, fmt.Sprintf("%s %q", strings.ReplaceAll(item.kind, "_", " "), item.name))
+	}
+	if len(injectedNames) > 0 {
+		i.Events.Eventf(pod, req.Namespace, corev1.EventTypeNormal, eventReasonInjected, "Injected %s", strings.Join(injectedNames, ", "))
 	}
 
-	return corev1.Container{}, fmt.Errorf("container not found")
+	// The reason of an injection is "injectors" if at least one injector
+	// matched the Pod, otherwise the resources were only defined via the
+	// annotations of the Pod.
+	reason = reasonAnnotations
+	if len(res.injectors) > 0 {
+		reason = reasonInjectors
+	}
+
+	log.Info("Inject sidecar.", "name", req.Name, "namespace", req.Namespace)
+	return admission.Patched("", patch.operations...).WithWarnings(warnings...), outcomeInjected, reason
 }
 
-func addEnvVariables(container corev1.Container, annotations map[string]string, environmentVariables []EnvironmentVariable) corev1.Container {
-	for _, envVar := range environmentVariables {
-		if envVar.Container == container.Name {
-			if val, ok := annotations[envVar.Annotation]; ok && val != "" {
-				container.Env = append(container.Env, corev1.EnvVar{
-					Name:  envVar.Name,
-					Value: val,
-				})
+// injectContainer adds the given container to the init containers or to the
+// containers of the Pod and returns if the container was injected. If the Pod
+// already contains a container with the same name, the given conflict strategy
+// is applied. For the "Skip" and "Replace" strategies a warning is returned,
+// which describes the decision.
+func injectContainer(pod *corev1.Pod, patch *podPatch, container corev1.Container, initContainer bool, strategy ConflictStrategy) (bool, string, error) {
+	var warning string
+
+	// The names of the init containers and containers must be unique within a
+	// Pod, so that we have to check both lists for a conflict.
+	initIndex := slices.IndexFunc(pod.Spec.InitContainers, func(c corev1.Container) bool { return c.Name == container.Name })
+	index := slices.IndexFunc(pod.Spec.Containers, func(c corev1.Container) bool { return c.Name == container.Name })
+
+	if initIndex >= 0 || index >= 0 {
+		switch strategy {
+		case ConflictStrategySkip:
+			return false, fmt.Sprintf("container %q was not injected, because the Pod already contains a container with the same name", container.Name), nil
+		case ConflictStrategyReplace:
+			if initIndex >= 0 {
+				patch.remove(pathInitContainers, initIndex)
+				pod.Spec.InitContainers = slices.Delete(pod.Spec.InitContainers, initIndex, initIndex+1)
+			}
+			if index >= 0 {
+				patch.remove(pathContainers, index)
+				pod.Spec.Containers = slices.Delete(pod.Spec.Containers, index, index+1)
 			}
+			warning = fmt.Sprintf("container %q of the Pod was replaced by the injected container", container.Name)
+		default:
+			return false, "", fmt.Errorf("container %q can not be injected, because the Pod already contains a container with the same name", container.Name)
 		}
 	}
 
-	return container
+	patch.appendContainer(pod, container, initContainer)
+
+	return true, warning, nil
 }
 
-func setResources(container corev1.Container, annotationKey string, annotations map[string]string) corev1.Container {
-	cpuRequestsAnnotation := fmt.Sprintf("%s-%s-%s", annotationKey, container.Name, "cpurequests")
-	cpuLimitsAnnotation := fmt.Sprintf("%s-%s-%s", annotationKey, container.Name, "cpulimits")
-	memoryRequestsAnnotation := fmt.Sprintf("%s-%s-%s", annotationKey, container.Name, "memoryrequests")
-	memoryLimitsAnnotation := fmt.Sprintf("%s-%s-%s", annotationKey, container.Name, "memorylimits")
+// isNativeSidecar returns true, when the restart policy of the given container
+// is "Always", which is only allowed for native sidecar containers.
+func isNativeSidecar(container corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
 
-	if val, ok := annotations[cpuRequestsAnnotation]; ok && val != "" {
-		quantity, err := resource.ParseQuantity(val)
-		if err != nil {
-			log.Error(err, "Could not parse cpu requests.", "containerName", container.Name, "annotation", cpuRequestsAnnotation, "value", val)
-		} else {
-			container.Resources.Requests["cpu"] = quantity
+// envTemplateData is the data, which is available in the template of an
+// environment variable. Besides the data of the Pod it contains the value of
+// the environment variable.
+type envTemplateData struct {
+	*templateData
+	Value string
+}
+
+// addEnvVariables adds the given environment variables to the container. The
+// values are taken from the Pod via the given template data. Environment
+// variables without a value are not added.
+func addEnvVariables(container corev1.Container, data *templateData, environmentVariables []EnvironmentVariable, templates templateCache) (corev1.Container, error) {
+	for _, envVar := range environmentVariables {
+		if !envVar.targets(container.Name) {
+			continue
 		}
-	}
 
-	if val, ok := annotations[cpuLimitsAnnotation]; ok && val != "" {
-		quantity, err := resource.ParseQuantity(val)
+		env, err := envVar.envVar(data, field.NewPath("environmentVariables").Key(envVar.Name), templates)
 		if err != nil {
-			log.Error(err, "Could not parse cpu limits.", "containerName", container.Name, "annotation", cpuLimitsAnnotation, "value", val)
-		} else {
-			container.Resources.Limits["cpu"] = quantity
+			return container, err
+		}
+		if env != nil {
+			container.Env = append(container.Env, *env)
 		}
 	}
 
-	if val, ok := annotations[memoryRequestsAnnotation]; ok && val != "" {
-		quantity, err := resource.ParseQuantity(val)
-		if err != nil {
-			log.Error(err, "Could not parse memory requests.", "containerName", container.Name, "annotation", memoryRequestsAnnotation, "value", val)
-		} else {
-			container.Resources.Requests["memory"] = quantity
-		}
+	return container, nil
+}
+
+// envVar returns the environment variable for the Pod of the given template
+// data. If the annotation or label is not set and the environment variable
+// doesn't have a default, nil is returned.
+func (e EnvironmentVariable) envVar(data *templateData, fldPath *field.Path, templates templateCache) (*corev1.EnvVar, error) {
+	switch {
+	case e.FieldRef != nil:
+		return &corev1.EnvVar{Name: e.Name, ValueFrom: &corev1.EnvVarSource{FieldRef: e.FieldRef.DeepCopy()}}, nil
+	case e.ResourceFieldRef != nil:
+		return &corev1.EnvVar{Name: e.Name, ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: e.ResourceFieldRef.DeepCopy()}}, nil
+	}
+
+	value := e.Value
+	switch {
+	case e.Annotation != "":
+		value = data.Annotations[e.Annotation]
+	case e.Label != "":
+		value = data.Labels[e.Label]
+	}
+	if value == "" {
+		value = e.Default
+	}
+	if value == "" {
+		return nil, nil
 	}
 
-	if val, ok := annotations[memoryLimitsAnnotation]; ok && val != "" {
-		quantity, err := resource.ParseQuantity(val)
+	value = e.Prefix + value
+	if e.Template != "" {
+		rendered, err := renderTemplate(e.Template, envTemplateData{templateData: data, Value: value}, fldPath.Child("template"), templates)
 		if err != nil {
-			log.Error(err, "Could not parse memory limits.", "containerName", container.Name, "annotation", memoryLimitsAnnotation, "value", val)
-		} else {
-			container.Resources.Limits["memory"] = quantity
+			return nil, err
 		}
+		value = rendered
+	}
+
+	return &corev1.EnvVar{Name: e.Name, Value: value}, nil
+}
+
+// injectVolume adds the given volume to the volumes of the Pod and returns if
+// the volume was injected. If the Pod already contains a volume with the same
+// name, the given conflict strategy is applied. For the "Skip" and "Replace"
+// strategies a warning is returned, which describes the decision.
+func injectVolume(pod *corev1.Pod, patch *podPatch, volume corev1.Volume, strategy ConflictStrategy) (bool, string, error) {
+	index := slices.IndexFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == volume.Name })
+	if index < 0 {
+		patch.appendVolume(pod, volume)
+		return true, "", nil
 	}
 
-	return container
+	switch strategy {
+	case ConflictStrategySkip:
+		return false, fmt.Sprintf("volume %q was not injected, because the Pod already contains a volume with the same name", volume.Name), nil
+	case ConflictStrategyReplace:
+		patch.replace(pathVolumes, index, volume)
+		pod.Spec.Volumes[index] = volume
+		return true, fmt.Sprintf("volume %q of the Pod was replaced by the injected volume", volume.Name), nil
+	default:
+		return false, "", fmt.Errorf("volume %q can not be injected, because the Pod already contains a volume with the same name", volume.Name)
+	}
 }
 
-func getVolume(name string, volumes []corev1.Volume) (corev1.Volume, error) {
-	for _, container := range volumes {
-		if container.Name == name {
-			return container, nil
+// appendUnique appends all values to the given slice, which are not already
+// contained in the slice.
+func appendUnique(slice []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(slice, value) {
+			slice = append(slice, value)
 		}
 	}
 
-	return corev1.Volume{}, fmt.Errorf("volume not found")
+	return slice
 }
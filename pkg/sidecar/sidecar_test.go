@@ -1,15 +1,1014 @@
 package sidecar
 
 import (
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+// handle creates an admission request for the given Pod and calls the Handle
+// function of the injector. If the request is allowed, the returned patch is
+// applied to the Pod, so that the returned Pod contains all injected
+// resources.
+func handle(injector *Injector, operation admissionv1.Operation, pod *corev1.Pod) (*corev1.Pod, admission.Response) {
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+
+	res := injector.Handle(ctx, admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if !res.Allowed || len(res.Patches) == 0 {
+		return pod, res
+	}
+
+	rawPatch, err := json.Marshal(res.Patches)
+	Expect(err).NotTo(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(rawPatch)
+	Expect(err).NotTo(HaveOccurred())
+	patched, err := patch.Apply(raw)
+	Expect(err).NotTo(HaveOccurred())
+
+	patchedPod := &corev1.Pod{}
+	Expect(json.Unmarshal(patched, patchedPod)).To(Succeed())
+	return patchedPod, res
+}
+
 var _ = Describe("Sidecar", func() {
+	Context("Getting resources to inject", func() {
+		injector := &Injector{}
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Name:       "logging",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"logging": "true"}},
+					Containers: []string{"log-shipper", "shared"},
+					Volumes:    []string{"logs"},
+				},
+				{
+					Name:       "auth",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"auth": "true"}},
+					Containers: []string{"auth-proxy", "shared"},
+					Priority:   10,
+				},
+				{
+					Name:       "exclusive-low",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"exclusive": "true"}},
+					Containers: []string{"exclusive-low"},
+					Exclusive:  true,
+				},
+				{
+					Name:       "exclusive-high",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"exclusive": "true"}},
+					Containers: []string{"exclusive-high"},
+					Priority:   5,
+					Exclusive:  true,
+				},
+				{
+					Name:       "security",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"security": "true"}},
+					Containers: []string{"security-agent"},
+					Mandatory:  true,
+				},
+			},
+		}
+
+		It("Should merge resources of all matching injectors by priority", func() {
+			res, reason, err := injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"logging": "true", "auth": "true"},
+					Annotations: map[string]string{
+						annotationContainersKey: "shared,extra",
+					},
+				},
+			}, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+			Expect(res.injectors).To(Equal([]string{"auth", "logging"}))
+			Expect(res.containers).To(Equal([]string{"auth-proxy", "shared", "log-shipper", "extra"}))
+			Expect(res.volumes).To(Equal([]string{"logs"}))
+		})
+
+		It("Should only apply injectors where the namespace selector matches", func() {
+			namespaceInjector := &Injector{
+				Client: fake.NewClientBuilder().WithObjects(
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+					&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout"}},
+				).Build(),
+			}
+			namespaceCfg := &Config{
+				Injectors: []InjectorData{
+					{
+						Name:              "payments",
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+						Containers:        []string{"auth-proxy"},
+					},
+				},
+			}
+
+			res, reason, err := namespaceInjector.getResourcesToInject(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "payments"}}, &corev1.Pod{}, namespaceCfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+			Expect(res.injectors).To(Equal([]string{"payments"}))
+			Expect(res.containers).To(Equal([]string{"auth-proxy"}))
+
+			_, reason, err = namespaceInjector.getResourcesToInject(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "checkout"}}, &corev1.Pod{}, namespaceCfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal(reasonNoMatch))
+		})
+
+		It("Should only apply the exclusive injector with the highest priority", func() {
+			res, reason, err := injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"logging": "true", "exclusive": "true"},
+				},
+			}, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+			Expect(res.injectors).To(Equal([]string{"exclusive-high"}))
+			Expect(res.containers).To(Equal([]string{"exclusive-high"}))
+			Expect(res.volumes).To(BeEmpty())
+		})
+
+		It("Should not inject resources when the injection is disabled", func() {
+			_, reason, err := injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"logging": "true", "auth": "true"},
+					Annotations: map[string]string{annotationInjectKey: "disabled"},
+				},
+			}, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal(reasonDisabled))
+		})
+
+		It("Should not inject excluded containers", func() {
+			res, reason, err := injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"logging": "true", "auth": "true"},
+					Annotations: map[string]string{annotationExcludeContainersKey: "shared,log-shipper"},
+				},
+			}, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+			Expect(res.containers).To(Equal([]string{"auth-proxy"}))
+			Expect(res.volumes).To(Equal([]string{"logs"}))
+
+			_, reason, err = injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"auth": "true"},
+					Annotations: map[string]string{annotationExcludeContainersKey: "shared,auth-proxy"},
+				},
+			}, cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(Equal(reasonExcluded))
+		})
+
+		It("Should reject opt-out of mandatory injectors", func() {
+			_, _, err := injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"security": "true"},
+					Annotations: map[string]string{annotationInjectKey: "disabled"},
+				},
+			}, cfg)
+			Expect(err).To(MatchError(`injector "security" is mandatory and can not be disabled via the "sidecar-injector.ricoberger.de" annotation`))
+
+			_, _, err = injector.getResourcesToInject(ctx, admission.Request{}, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"security": "true"},
+					Annotations: map[string]string{annotationExcludeContainersKey: "security-agent"},
+				},
+			}, cfg)
+			Expect(err).To(MatchError(`container "security-agent" of the mandatory injector "security" can not be excluded via the "sidecar-injector.ricoberger.de/exclude-containers" annotation`))
+		})
+	})
+
+	Context("Injecting native sidecar containers", func() {
+		always := corev1.ContainerRestartPolicyAlways
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Name:           "native",
+					Selector:       metav1.LabelSelector{MatchLabels: map[string]string{"native": "true"}},
+					Containers:     []string{"log-shipper"},
+					NativeSidecars: true,
+				},
+				{
+					Name:           "per-container",
+					Selector:       metav1.LabelSelector{MatchLabels: map[string]string{"per-container": "true"}},
+					InitContainers: []string{"init"},
+					Containers:     []string{"auth-proxy"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper"}},
+				{Container: corev1.Container{Name: "auth-proxy", Image: "auth-proxy", RestartPolicy: &always}},
+				{Container: corev1.Container{Name: "init", Image: "init"}},
+			},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "native-sidecars",
+				Namespace: "default",
+				Labels:    map[string]string{"native": "true", "per-container": "true"},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "app", Image: "app"}},
+			},
+		}
+
+		It("Should inject containers as native sidecar containers", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme), NativeSidecars: true}
+
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(len(patchedPod.Spec.Containers)).To(Equal(1))
+			Expect(len(patchedPod.Spec.InitContainers)).To(Equal(3))
+			Expect(patchedPod.Spec.InitContainers[0].Name).To(Equal("log-shipper"))
+			Expect(patchedPod.Spec.InitContainers[0].RestartPolicy).To(Equal(&always))
+			Expect(patchedPod.Spec.InitContainers[1].Name).To(Equal("auth-proxy"))
+			Expect(patchedPod.Spec.InitContainers[1].RestartPolicy).To(Equal(&always))
+			Expect(patchedPod.Spec.InitContainers[2].Name).To(Equal("init"))
+			Expect(patchedPod.Spec.InitContainers[2].RestartPolicy).To(BeNil())
+		})
+
+		It("Should inject native sidecar containers before the init containers of the Pod", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme), NativeSidecars: true}
+
+			podWithInitContainers := pod.DeepCopy()
+			podWithInitContainers.Spec.InitContainers = []corev1.Container{
+				{Name: "vault-agent", Image: "vault-agent", RestartPolicy: &always},
+				{Name: "migrate", Image: "migrate"},
+			}
+
+			patchedPod, res := handle(injector, admissionv1.Create, podWithInitContainers)
+			Expect(res.Allowed).To(BeTrue())
+			var names []string
+			for _, container := range patchedPod.Spec.InitContainers {
+				names = append(names, container.Name)
+			}
+			Expect(names).To(Equal([]string{"vault-agent", "log-shipper", "auth-proxy", "migrate", "init"}))
+		})
+
+		It("Should inject containers as regular containers when native sidecar containers are not supported", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(len(patchedPod.Spec.InitContainers)).To(Equal(1))
+			Expect(len(patchedPod.Spec.Containers)).To(Equal(3))
+			Expect(patchedPod.Spec.Containers[1].Name).To(Equal("log-shipper"))
+			Expect(patchedPod.Spec.Containers[1].RestartPolicy).To(BeNil())
+			Expect(patchedPod.Spec.Containers[2].Name).To(Equal("auth-proxy"))
+			Expect(patchedPod.Spec.Containers[2].RestartPolicy).To(BeNil())
+		})
+
+		It("Should detect native sidecar support from the server version", func() {
+			for gitVersion, supported := range map[string]bool{
+				"v1.28.5":          false,
+				"v1.29.0":          true,
+				"v1.31.2-gke.1200": true,
+			} {
+				discoveryClient := &fakediscovery.FakeDiscovery{
+					Fake:               &clienttesting.Fake{},
+					FakedServerVersion: &version.Info{GitVersion: gitVersion},
+				}
+
+				ok, err := SupportsNativeSidecars(discoveryClient)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ok).To(Equal(supported), gitVersion)
+			}
+		})
+	})
+
+	Context("Injecting conflicting containers and volumes", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "conflict"}},
+					Containers: []string{"fail", "skip", "replace"},
+					Volumes:    []string{"config"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "fail", Image: "injected"}, OnConflict: ConflictStrategyFail},
+				{Container: corev1.Container{Name: "skip", Image: "injected"}, OnConflict: ConflictStrategySkip},
+				{Container: corev1.Container{Name: "replace", Image: "injected"}, OnConflict: ConflictStrategyReplace},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			},
+			OnConflict: ConflictStrategyReplace,
+		}
+		injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+		newPod := func(containers ...string) *corev1.Pod {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "conflict", Namespace: "default", Labels: map[string]string{"app": "conflict"}},
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/config"}}}},
+				},
+			}
+			for _, container := range containers {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container, Image: "app"})
+			}
+			return pod
+		}
+
+		It("Should reject Pods with a conflicting container", func() {
+			_, res := handle(injector, admissionv1.Create, newPod("fail"))
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`container "fail" can not be injected, because the Pod already contains a container with the same name`))
+		})
+
+		It("Should skip and replace conflicting containers and volumes", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, newPod("skip", "replace"))
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`container "skip" was not injected, because the Pod already contains a container with the same name`,
+				`container "replace" of the Pod was replaced by the injected container`,
+				`volume "config" of the Pod was replaced by the injected volume`,
+			}))
+
+			Expect(len(patchedPod.Spec.Containers)).To(Equal(3))
+			Expect(patchedPod.Spec.Containers[0].Name).To(Equal("skip"))
+			Expect(patchedPod.Spec.Containers[0].Image).To(Equal("app"))
+			Expect(patchedPod.Spec.Containers[1].Name).To(Equal("fail"))
+			Expect(patchedPod.Spec.Containers[2].Name).To(Equal("replace"))
+			Expect(patchedPod.Spec.Containers[2].Image).To(Equal("injected"))
+			Expect(len(patchedPod.Spec.Volumes)).To(Equal(1))
+			Expect(patchedPod.Spec.Volumes[0].EmptyDir).NotTo(BeNil())
+			Expect(patchedPod.Spec.Volumes[0].HostPath).To(BeNil())
+		})
+	})
+
+	Context("Mutating app containers", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Name:       "proxy",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "mutate"}},
+					Containers: []string{"proxy"},
+					Volumes:    []string{"socket"},
+					AppContainers: []AppContainerMutation{
+						{
+							Containers:   []string{"app-*"},
+							VolumeMounts: []corev1.VolumeMount{{Name: "socket", MountPath: "/var/run/proxy"}},
+							Env:          []corev1.EnvVar{{Name: "PROXY_SOCKET", Value: "/var/run/proxy/{{ .Name }}.sock"}},
+							EnvFrom:      []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "proxy"}}}},
+							Templates:    true,
+						},
+					},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "proxy", Image: "proxy", VolumeMounts: []corev1.VolumeMount{{Name: "socket", MountPath: "/var/run/proxy"}}}},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "socket", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			},
+		}
+
+		newPod := func(containers ...corev1.Container) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "mutate", Namespace: "default", Labels: map[string]string{"app": "mutate"}},
+				Spec:       corev1.PodSpec{Containers: containers},
+			}
+		}
+
+		It("Should add volume mounts and environment variables to matching app containers", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, newPod(
+				corev1.Container{Name: "app-server", Image: "app", Env: []corev1.EnvVar{{Name: "KEY", Value: "value"}}},
+				corev1.Container{Name: "worker", Image: "app"},
+			))
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(BeEmpty())
+
+			Expect(len(patchedPod.Spec.Containers)).To(Equal(3))
+			Expect(patchedPod.Spec.Containers[0].VolumeMounts).To(Equal([]corev1.VolumeMount{{Name: "socket", MountPath: "/var/run/proxy"}}))
+			Expect(patchedPod.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: "KEY", Value: "value"}, {Name: "PROXY_SOCKET", Value: "/var/run/proxy/mutate.sock"}}))
+			Expect(patchedPod.Spec.Containers[0].EnvFrom).To(HaveLen(1))
+			Expect(patchedPod.Spec.Containers[1].VolumeMounts).To(BeEmpty())
+			Expect(patchedPod.Spec.Containers[1].Env).To(BeEmpty())
+			Expect(patchedPod.Spec.Containers[2].Name).To(Equal("proxy"))
+			Expect(patchedPod.Spec.Containers[2].Env).To(BeEmpty())
+
+			rec, err := getRecord(patchedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rec.AppContainers).To(Equal([]recordItem{{Name: "app-server", Injectors: []string{"proxy"}}}))
+		})
+
+		It("Should apply the conflict strategy for existing mount paths and environment variables", func() {
+			conflicting := func() *corev1.Pod {
+				return newPod(corev1.Container{
+					Name:         "app-server",
+					Image:        "app",
+					VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/var/run/proxy"}},
+					Env:          []corev1.EnvVar{{Name: "PROXY_SOCKET", Value: "/tmp/proxy.sock"}},
+				})
+			}
+
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			_, res := handle(injector, admissionv1.Create, conflicting())
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`volume mount "/var/run/proxy" can not be added to container "app-server", because the container already contains an entry with the same path`))
+
+			skipCfg := *cfg
+			skipCfg.OnConflict = ConflictStrategySkip
+			injector = &Injector{Config: &skipCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, conflicting())
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`volume mount "/var/run/proxy" was not added to container "app-server", because the container already contains an entry with the same path`,
+				`environment variable "PROXY_SOCKET" was not added to container "app-server", because the container already contains an entry with the same name`,
+			}))
+			Expect(patchedPod.Spec.Containers[0].VolumeMounts).To(Equal([]corev1.VolumeMount{{Name: "data", MountPath: "/var/run/proxy"}}))
+			Expect(patchedPod.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: "PROXY_SOCKET", Value: "/tmp/proxy.sock"}}))
+
+			replaceCfg := *cfg
+			replaceCfg.OnConflict = ConflictStrategyReplace
+			injector = &Injector{Config: &replaceCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res = handle(injector, admissionv1.Create, conflicting())
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`volume mount "/var/run/proxy" of container "app-server" was replaced`,
+				`environment variable "PROXY_SOCKET" of container "app-server" was replaced`,
+			}))
+			Expect(patchedPod.Spec.Containers[0].VolumeMounts).To(Equal([]corev1.VolumeMount{{Name: "socket", MountPath: "/var/run/proxy"}}))
+			Expect(patchedPod.Spec.Containers[0].Env).To(Equal([]corev1.EnvVar{{Name: "PROXY_SOCKET", Value: "/var/run/proxy/mutate.sock"}}))
+		})
+	})
+
+	Context("Adding volumes required by injected containers", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Name:       "logging",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "volumes"}},
+					Containers: []string{"log-shipper"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper", VolumeMounts: []corev1.VolumeMount{
+					{Name: "logs", MountPath: "/logs"},
+					{Name: "config", MountPath: "/config"},
+				}}},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			},
+		}
+		injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+		newPod := func(volumes ...string) *corev1.Pod {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "volumes", Namespace: "default", Labels: map[string]string{"app": "volumes"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			for _, volume := range volumes {
+				pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: volume, VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}})
+			}
+			return pod
+		}
+
+		It("Should inject volumes from the configuration, which are mounted by injected containers", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, newPod("config"))
+			Expect(res.Allowed).To(BeTrue())
+
+			Expect(len(patchedPod.Spec.Volumes)).To(Equal(2))
+			Expect(patchedPod.Spec.Volumes[0].Name).To(Equal("config"))
+			Expect(patchedPod.Spec.Volumes[0].ConfigMap).NotTo(BeNil())
+			Expect(patchedPod.Spec.Volumes[1].Name).To(Equal("logs"))
+			Expect(patchedPod.Spec.Volumes[1].EmptyDir).NotTo(BeNil())
+
+			rec, err := getRecord(patchedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rec.Volumes).To(Equal([]recordItem{{Name: "logs", Injectors: []string{"logging"}}}))
+		})
+
+		It("Should reject Pods, when a mounted volume is not defined", func() {
+			_, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`volume "config" of volume mount "/config" in container "log-shipper" is defined neither in the Pod nor in the configuration`))
+		})
+	})
+
+	Context("Overriding resources of injected containers", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "resources"}},
+					Containers: []string{"proxy"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+			},
+		}
+
+		It("Should set resources for containers without requests and limits", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "resources",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "resources"},
+					Annotations: map[string]string{
+						"sidecar-injector.ricoberger.de/containers-proxy-cpurequests":  "100m",
+						"sidecar-injector.ricoberger.de/containers-proxy-memorylimits": "128Mi",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			})
+			Expect(res.Allowed).To(BeTrue())
+			Expect(patchedPod.Spec.Containers[1].Resources.Requests).To(Equal(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}))
+			Expect(patchedPod.Spec.Containers[1].Resources.Limits).To(Equal(corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")}))
+		})
+
+		It("Should use the annotations of the Namespace as defaults", func() {
+			injector := &Injector{
+				Config:  cfg,
+				Decoder: admission.NewDecoder(scheme.Scheme),
+				namespaces: map[string]*corev1.Namespace{
+					"team": {ObjectMeta: metav1.ObjectMeta{Name: "team", Annotations: map[string]string{
+						"sidecar-injector.ricoberger.de/containers-proxy-resources": `{"requests": {"cpu": "50m", "memory": "64Mi"}}`,
+					}}},
+				},
+			}
+			patchedPod, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "resources",
+					Namespace:   "team",
+					Labels:      map[string]string{"app": "resources"},
+					Annotations: map[string]string{"sidecar-injector.ricoberger.de/containers-proxy-cpurequests": "200m"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			})
+			Expect(res.Allowed).To(BeTrue())
+			Expect(patchedPod.Spec.Containers[1].Resources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("200m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			}))
+		})
+
+		It("Should set extended resources and remove requests and limits", func() {
+			container := corev1.Container{
+				Name: "proxy",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("200m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+				},
+			}
+
+			container, messages := setResources(container, annotationContainersKey, nil, map[string]string{
+				"sidecar-injector.ricoberger.de/containers-proxy-resources":                "requests:\n  nvidia.com/gpu: 1\nlimits:\n  nvidia.com/gpu: 1\n  cpu: null\n",
+				"sidecar-injector.ricoberger.de/containers-proxy-ephemeralstoragerequests": "1Gi",
+				"sidecar-injector.ricoberger.de/containers-proxy-memorylimits":             "none",
+			}, nil)
+			Expect(messages).To(Equal(resourceMessages{}))
+			Expect(container.Resources.Requests).To(Equal(corev1.ResourceList{
+				corev1.ResourceCPU:              resource.MustParse("100m"),
+				corev1.ResourceMemory:           resource.MustParse("64Mi"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				"nvidia.com/gpu":                resource.MustParse("1"),
+			}))
+			Expect(container.Resources.Limits).To(Equal(corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}))
+		})
+
+		It("Should keep overwritten resources within the bounds", func() {
+			container := corev1.Container{
+				Name: "proxy",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+				},
+			}
+			bounds := &ResourceBounds{
+				Min: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")},
+				Max: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			}
+
+			container, messages := setResources(container, annotationContainersKey, nil, map[string]string{
+				"sidecar-injector.ricoberger.de/containers-proxy-cpurequests":  "10m",
+				"sidecar-injector.ricoberger.de/containers-proxy-cpulimits":    "2",
+				"sidecar-injector.ricoberger.de/containers-proxy-memorylimits": "none",
+			}, bounds)
+			Expect(messages.invalid).To(BeEmpty())
+			Expect(messages.clamped).To(Equal([]string{
+				`cpu requests "10m" of container "proxy" were raised to the minimum of "50m"`,
+				`cpu limits "2" of container "proxy" were lowered to the maximum of "1"`,
+				`memory limits of container "proxy" can not be removed and were set to the maximum of "1Gi"`,
+			}))
+			Expect(container.Resources.Requests).To(Equal(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("50m")}))
+			Expect(container.Resources.Limits).To(Equal(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")}))
+		})
+
+		It("Should report annotations which can not be parsed", func() {
+			_, messages := setResources(corev1.Container{Name: "proxy"}, annotationContainersKey, map[string]string{
+				"sidecar-injector.ricoberger.de/containers-proxy-ephemeralstoragelimits": "invalid",
+			}, map[string]string{
+				"sidecar-injector.ricoberger.de/containers-proxy-resources": `{"requests": {"cpu": "invalid"}}`,
+			}, nil)
+			Expect(messages.namespace).To(Equal([]string{`could not parse ephemeral-storage limits "invalid" from annotation "sidecar-injector.ricoberger.de/containers-proxy-ephemeralstoragelimits" of the Namespace`}))
+			Expect(messages.invalid).To(HaveLen(1))
+			Expect(messages.invalid[0]).To(HavePrefix(`could not parse resources "{\"requests\": {\"cpu\": \"invalid\"}}" from annotation "sidecar-injector.ricoberger.de/containers-proxy-resources": `))
+		})
+	})
+
+	Context("Adding environment variables", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:       metav1.LabelSelector{MatchLabels: map[string]string{"app": "env"}},
+					InitContainers: []string{"setup"},
+					Containers:     []string{"proxy", "exporter"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "setup", Image: "setup"}},
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+				{Container: corev1.Container{Name: "exporter", Image: "exporter"}},
+			},
+			EnvironmentVariables: []EnvironmentVariable{
+				{Name: "APP", Label: "app"},
+				{Name: "LOG_LEVEL", Containers: []string{"proxy", "exporter"}, Annotation: "example.com/log-level", Default: "info"},
+				{Name: "POD_IP", Container: "proxy", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}},
+				{Name: "CPU_LIMIT", Container: "proxy", ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.cpu"}},
+				{Name: "SERVICE", Container: "exporter", Value: "exporter", Prefix: "svc-", Template: "{{ .Value }}.{{ .Namespace }}"},
+				{Name: "TEAM", Container: "exporter", Label: "team"},
+			},
+		}
+		injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+		It("Should add environment variables from all sources to the target containers", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "env", Namespace: "default", Labels: map[string]string{"app": "env"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			})
+			Expect(res.Allowed).To(BeTrue())
+
+			Expect(patchedPod.Spec.InitContainers[0].Env).To(Equal([]corev1.EnvVar{{Name: "APP", Value: "env"}}))
+			Expect(patchedPod.Spec.Containers[1].Env).To(HaveLen(4))
+			Expect(patchedPod.Spec.Containers[1].Env[:3]).To(Equal([]corev1.EnvVar{
+				{Name: "APP", Value: "env"},
+				{Name: "LOG_LEVEL", Value: "info"},
+				{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+			}))
+			Expect(patchedPod.Spec.Containers[1].Env[3].Name).To(Equal("CPU_LIMIT"))
+			Expect(patchedPod.Spec.Containers[1].Env[3].ValueFrom.ResourceFieldRef.Resource).To(Equal("limits.cpu"))
+			Expect(patchedPod.Spec.Containers[2].Env).To(Equal([]corev1.EnvVar{
+				{Name: "APP", Value: "env"},
+				{Name: "LOG_LEVEL", Value: "info"},
+				{Name: "SERVICE", Value: "svc-exporter.default"},
+			}))
+		})
+
+		It("Should use the value of the annotation instead of the default", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "env",
+					Namespace:   "default",
+					Labels:      map[string]string{"app": "env", "team": "payments"},
+					Annotations: map[string]string{"example.com/log-level": "debug"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			})
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(BeEmpty())
+
+			Expect(patchedPod.Spec.Containers[2].Env).To(ContainElements(
+				corev1.EnvVar{Name: "LOG_LEVEL", Value: "debug"},
+				corev1.EnvVar{Name: "TEAM", Value: "payments"},
+			))
+		})
+	})
+
+	Context("Mutating the Pod", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Name:       "proxy",
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "pod"}},
+					Containers: []string{"proxy"},
+					Pod: &PodMutation{
+						Labels:                        map[string]string{"mesh": "enabled", "app": "pod"},
+						Annotations:                   map[string]string{"prometheus.io/scrape": "true", "proxy.example.com/owner": "{{ .Labels.team }}"},
+						ImagePullSecrets:              []corev1.LocalObjectReference{{Name: "registry"}},
+						ShareProcessNamespace:         ptr.To(true),
+						HostAliases:                   []corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"proxy.local"}}},
+						DNSConfig:                     &corev1.PodDNSConfig{Searches: []string{"mesh.local"}, Options: []corev1.PodDNSConfigOption{{Name: "ndots", Value: ptr.To("2")}}},
+						Tolerations:                   []corev1.Toleration{{Key: "proxy", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}},
+						TerminationGracePeriodSeconds: ptr.To(int64(60)),
+						Templates:                     true,
+					},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+			},
+		}
+
+		newPod := func() *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "pod", "team": "a"}},
+				Spec: corev1.PodSpec{
+					Containers:                    []corev1.Container{{Name: "app", Image: "app"}},
+					ImagePullSecrets:              []corev1.LocalObjectReference{{Name: "registry"}},
+					HostAliases:                   []corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"app.local"}}},
+					TerminationGracePeriodSeconds: ptr.To(int64(30)),
+				},
+			}
+		}
+
+		It("Should merge the mutation with the metadata and the specification of the Pod", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(BeEmpty())
+
+			Expect(patchedPod.Labels).To(Equal(map[string]string{"app": "pod", "team": "a", "mesh": "enabled"}))
+			Expect(patchedPod.Annotations).To(HaveKeyWithValue("prometheus.io/scrape", "true"))
+			Expect(patchedPod.Annotations).To(HaveKeyWithValue("proxy.example.com/owner", "a"))
+			Expect(patchedPod.Spec.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "registry"}}))
+			Expect(*patchedPod.Spec.ShareProcessNamespace).To(BeTrue())
+			Expect(patchedPod.Spec.HostAliases).To(Equal([]corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{"app.local", "proxy.local"}}}))
+			Expect(patchedPod.Spec.DNSConfig).To(Equal(&corev1.PodDNSConfig{Searches: []string{"mesh.local"}, Options: []corev1.PodDNSConfigOption{{Name: "ndots", Value: ptr.To("2")}}}))
+			Expect(patchedPod.Spec.Tolerations).To(Equal([]corev1.Toleration{{Key: "proxy", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}))
+			Expect(*patchedPod.Spec.TerminationGracePeriodSeconds).To(Equal(int64(60)))
+
+			rec, err := getRecord(patchedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rec.Pod).To(Equal([]recordItem{
+				{Name: "labels", Injectors: []string{"proxy"}},
+				{Name: "annotations", Injectors: []string{"proxy"}},
+				{Name: "shareProcessNamespace", Injectors: []string{"proxy"}},
+				{Name: "hostAliases", Injectors: []string{"proxy"}},
+				{Name: "dnsConfig", Injectors: []string{"proxy"}},
+				{Name: "tolerations", Injectors: []string{"proxy"}},
+				{Name: "terminationGracePeriodSeconds", Injectors: []string{"proxy"}},
+			}))
+		})
+
+		It("Should not lower the termination grace period", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			pod := newPod()
+			pod.Spec.TerminationGracePeriodSeconds = ptr.To(int64(120))
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(*patchedPod.Spec.TerminationGracePeriodSeconds).To(Equal(int64(120)))
+		})
+
+		It("Should apply the conflict strategy for conflicting values", func() {
+			pod := newPod()
+			pod.Labels["mesh"] = "disabled"
+			pod.Spec.ShareProcessNamespace = ptr.To(false)
+
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			_, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`label "mesh" can not be set, because the Pod already contains a different value`))
+
+			skipCfg := *cfg
+			skipCfg.OnConflict = ConflictStrategySkip
+			injector = &Injector{Config: &skipCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`label "mesh" was not set, because the Pod already contains a different value`,
+				`process namespace sharing was not set, because the Pod already contains a different value`,
+			}))
+			Expect(patchedPod.Labels["mesh"]).To(Equal("disabled"))
+			Expect(*patchedPod.Spec.ShareProcessNamespace).To(BeFalse())
+		})
+
+		It("Should skip conflicting labels when the Replace strategy is inherited", func() {
+			pod := newPod()
+			pod.Labels["mesh"] = "disabled"
+			pod.Spec.ShareProcessNamespace = ptr.To(false)
+
+			replaceCfg := *cfg
+			replaceCfg.OnConflict = ConflictStrategyReplace
+			injector := &Injector{Config: &replaceCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`label "mesh" was not set, because the Pod already contains a different value`,
+				`process namespace sharing of the Pod was replaced`,
+			}))
+			Expect(patchedPod.Labels["mesh"]).To(Equal("disabled"))
+			Expect(*patchedPod.Spec.ShareProcessNamespace).To(BeTrue())
+		})
+	})
+
+	Context("Setting volume parameters", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "parameters"}},
+					Volumes:  []string{"credentials", "token", "cache"},
+				},
+			},
+			Volumes: []Volume{
+				{
+					Volume: corev1.Volume{Name: "credentials", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "default-credentials"}}},
+					Parameters: []VolumeParameter{
+						{Annotation: "example.com/credentials", Field: VolumeParameterSecretName, Pattern: "team-a-.*"},
+					},
+				},
+				{
+					Volume: corev1.Volume{Name: "token", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+						{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Audience: "default", Path: "token"}},
+					}}}},
+					Parameters: []VolumeParameter{
+						{Annotation: "example.com/audience", Field: VolumeParameterAudience, Pattern: "vault|sts"},
+						{Annotation: "example.com/expiration", Field: VolumeParameterExpirationSeconds, Pattern: "[0-9]+"},
+					},
+				},
+				{
+					Volume: corev1.Volume{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+					Parameters: []VolumeParameter{
+						{Annotation: "example.com/cache-size", Field: VolumeParameterSizeLimit, Pattern: "[0-9]+[MG]i"},
+					},
+				},
+			},
+		}
+		injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+		newPod := func(annotations map[string]string) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "parameters", Namespace: "default", Labels: map[string]string{"app": "parameters"}, Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+		}
+
+		It("Should set the fields of the volumes from the annotations", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, newPod(map[string]string{
+				"example.com/credentials": "team-a-credentials",
+				"example.com/audience":    "vault",
+				"example.com/expiration":  "3600",
+				"example.com/cache-size":  "1Gi",
+			}))
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(BeEmpty())
+
+			Expect(len(patchedPod.Spec.Volumes)).To(Equal(3))
+			Expect(patchedPod.Spec.Volumes[0].Secret.SecretName).To(Equal("team-a-credentials"))
+			Expect(patchedPod.Spec.Volumes[1].Projected.Sources[0].ServiceAccountToken.Audience).To(Equal("vault"))
+			Expect(*patchedPod.Spec.Volumes[1].Projected.Sources[0].ServiceAccountToken.ExpirationSeconds).To(Equal(int64(3600)))
+			Expect(patchedPod.Spec.Volumes[2].EmptyDir.SizeLimit.String()).To(Equal("1Gi"))
+
+			Expect(cfg.Volumes[0].Secret.SecretName).To(Equal("default-credentials"))
+		})
+
+		It("Should use the definition and return warnings for values which are not allowed", func() {
+			patchedPod, res := handle(injector, admissionv1.Create, newPod(map[string]string{
+				"example.com/credentials": "team-b-credentials",
+				"example.com/cache-size":  "100Gi",
+			}))
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`secretName "team-b-credentials" from annotation "example.com/credentials" is not allowed for volume "credentials"`,
+			}))
+
+			Expect(len(patchedPod.Spec.Volumes)).To(Equal(3))
+			Expect(patchedPod.Spec.Volumes[0].Secret.SecretName).To(Equal("default-credentials"))
+			Expect(patchedPod.Spec.Volumes[2].EmptyDir.SizeLimit.String()).To(Equal("100Gi"))
+		})
+
+		It("Should deny values which are not allowed in strict mode", func() {
+			strictCfg := *cfg
+			strictCfg.Strict = true
+			strictInjector := &Injector{Config: &strictCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+			_, res := handle(strictInjector, admissionv1.Create, newPod(map[string]string{"example.com/audience": "other"}))
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`invalid annotations: audience "other" from annotation "example.com/audience" is not allowed for volume "token"`))
+		})
+	})
+
+	Context("Reporting invalid annotations", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "annotations"}},
+					Containers: []string{"proxy"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}},
+				{Container: corev1.Container{Name: "debug", Image: "debug"}},
+			},
+			EnvironmentVariables: []EnvironmentVariable{
+				{Name: "DEBUG", Container: "debug", Annotation: "example.com/debug"},
+			},
+		}
+
+		newPod := func() *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "annotations",
+					Namespace: "default",
+					Labels:    map[string]string{"app": "annotations"},
+					Annotations: map[string]string{
+						annotationExcludeContainersKey:                                 "unknown",
+						"example.com/debug":                                            "true",
+						"sidecar-injector.ricoberger.de/containers-proxy-cpurequests":  "invalid",
+						"sidecar-injector.ricoberger.de/containers-debug-memorylimits": "1Gi",
+						"sidecar-injector.ricoberger.de/contaners":                     "proxy",
+					},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+		}
+
+		It("Should return warnings for ignored and malformed annotations", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			patchedPod, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`annotation "example.com/debug" is not used, because environment variable "DEBUG" is not added to an injected container`,
+				`annotation "sidecar-injector.ricoberger.de/containers-debug-memorylimits" is ignored, because container "debug" is not injected`,
+				`annotation "sidecar-injector.ricoberger.de/contaners" is unknown`,
+				`container "unknown" from annotation "sidecar-injector.ricoberger.de/exclude-containers" is not defined in the configuration`,
+				`could not parse cpu requests "invalid" from annotation "sidecar-injector.ricoberger.de/containers-proxy-cpurequests"`,
+			}))
+			Expect(len(patchedPod.Spec.Containers)).To(Equal(2))
+			Expect(patchedPod.Spec.Containers[1].Name).To(Equal("proxy"))
+		})
+
+		It("Should reject Pods with containers and volumes which are not defined", func() {
+			injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+			pod := newPod()
+			pod.Annotations = map[string]string{annotationContainersKey: "missing"}
+			_, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`container "missing" from annotation "sidecar-injector.ricoberger.de/containers" is not defined in the configuration`))
+
+			pod.Annotations = map[string]string{annotationVolumesKey: "missing"}
+			_, res = handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`volume "missing" from annotation "sidecar-injector.ricoberger.de/volumes" is not defined in the configuration`))
+		})
+
+		It("Should deny Pods with ignored and malformed annotations in strict mode", func() {
+			strictCfg := *cfg
+			strictCfg.Strict = true
+			injector := &Injector{Config: &strictCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+			pod := newPod()
+			pod.Annotations = map[string]string{"sidecar-injector.ricoberger.de/containers-proxy-cpurequests": "invalid"}
+			_, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`invalid annotations: could not parse cpu requests "invalid" from annotation "sidecar-injector.ricoberger.de/containers-proxy-cpurequests"`))
+
+			pod.Annotations = map[string]string{"sidecar-injector.ricoberger.de/containers-proxy-cpurequests": "100m"}
+			_, res = handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(BeEmpty())
+		})
+
+		It("Should allow clamped resources and malformed Namespace annotations in strict mode", func() {
+			strictCfg := *cfg
+			strictCfg.Strict = true
+			strictCfg.Containers = []Container{
+				{Container: corev1.Container{Name: "proxy", Image: "proxy"}, ResourceBounds: &ResourceBounds{Max: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}}},
+			}
+			injector := &Injector{Config: &strictCfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+			injector.namespaces = map[string]*corev1.Namespace{
+				"default": {ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: map[string]string{"sidecar-injector.ricoberger.de/containers-proxy-memorylimits": "invalid"}}},
+			}
+
+			pod := newPod()
+			pod.Annotations = map[string]string{"sidecar-injector.ricoberger.de/containers-proxy-cpurequests": "2"}
+			patchedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Warnings).To(Equal([]string{
+				`cpu requests "2" of container "proxy" were lowered to the maximum of "1"`,
+				`could not parse memory limits "invalid" from annotation "sidecar-injector.ricoberger.de/containers-proxy-memorylimits" of the Namespace`,
+			}))
+			Expect(patchedPod.Spec.Containers[1].Resources.Requests).To(Equal(corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}))
+		})
+	})
+
 	Context("Creating and updating Pods", func() {
 		It("Should inject sidecar into Pods which are matching selector from injector configuration", func() {
 			By("Create Pod")
@@ -48,6 +1047,7 @@ var _ = Describe("Sidecar", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(pod.Annotations[annotationStatusKey]).To(Equal("injected"))
+			Expect(pod.Annotations[annotationInjectorsKey]).To(Equal("injectors[0]"))
 			Expect(len(pod.Spec.InitContainers)).To(Equal(1))
 			Expect(len(pod.Spec.Containers)).To(Equal(2))
 			Expect(len(pod.Spec.Volumes)).To(Equal(1))
@@ -392,6 +1392,50 @@ var _ = Describe("Sidecar", func() {
 			Expect(len(pod.Spec.Containers[1].Env)).To(Equal(0))
 		})
 
+		It("Should inject sidecar into Pods in Namespaces which are matching the namespace selector", func() {
+			By("Create Namespace")
+			err := k8sClient.Create(ctx, &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "payments",
+					Labels: map[string]string{
+						"team": "payments",
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Create Pods")
+			for _, namespace := range []string{"payments", "default"} {
+				// The Namespace is read from the cache of the webhook, so that
+				// the creation of the Pod can fail until the cache is synced.
+				Eventually(func() error {
+					return k8sClient.Create(ctx, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "test-pod-namespace",
+							Namespace: namespace,
+							Labels: map[string]string{
+								"sidecar-injector": "namespace-injector-test",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "my-container", Image: "my-image"}},
+						},
+					})
+				}).Should(Succeed())
+			}
+
+			pod := &corev1.Pod{}
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: "test-pod-namespace", Namespace: "payments"}, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations[annotationInjectorsKey]).To(Equal("namespace-injector"))
+			Expect(len(pod.Spec.Containers)).To(Equal(2))
+
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: "test-pod-namespace", Namespace: "default"}, pod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Annotations).To(BeNil())
+			Expect(len(pod.Spec.Containers)).To(Equal(1))
+		})
+
 		It("Should do nothing if pod does not require injection", func() {
 			By("Create Pod")
 			err := k8sClient.Create(ctx, &corev1.Pod{
@@ -0,0 +1,237 @@
+package sidecar
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	annotationRestartedAtKey = "sidecar-injector.ricoberger.de/restartedAt"
+
+	eventReasonOutdatedSidecars = "OutdatedSidecars"
+)
+
+// staleRequest is the only request, which is handled by the StaleReconciler.
+// Since a change of the configuration can affect all Pods, we always check
+// all Pods at once.
+var staleRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: "stale"}}
+
+// StaleReconciler detects Pods, which were injected with outdated definitions
+// of the init containers, containers and volumes. For this the revision of the
+// injected resources in the `sidecar-injector.ricoberger.de/record` annotation
+// of a Pod is compared with the revision of the resources, which
+// would be injected with the current configuration.
+//
+// The number of outdated Pods is exposed per workload via a metric and an
+// Event. If RestartWorkloads is enabled, Deployments and StatefulSets with
+// outdated Pods are restarted.
+type StaleReconciler struct {
+	Client   client.Client
+	Injector *Injector
+
+	// RestartWorkloads enables the rolling restart of Deployments and
+	// StatefulSets with outdated Pods.
+	RestartWorkloads bool
+
+	// Interval is the interval in which all Pods are checked, so that changes
+	// of the configuration file are also detected, when no Pod is changed.
+	Interval time.Duration
+}
+
+func (r *StaleReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	cfg, err := r.Injector.getConfig(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.Client.List(ctx, pods); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	type outdatedWorkload struct {
+		workload *metav1.PartialObjectMetadata
+		pod      *corev1.Pod
+		pods     int
+	}
+	outdatedWorkloads := make(map[types.UID]*outdatedWorkload)
+
+	// The workloads are cached by the controller of the Pods, so that the
+	// owner chain is only resolved once for all Pods of a ReplicaSet or Job.
+	workloads := make(map[types.UID]*metav1.PartialObjectMetadata)
+
+	for index := range pods.Items {
+		pod := &pods.Items[index]
+
+		outdated, err := r.isOutdated(ctx, pod, cfg)
+		if err != nil {
+			log.Error(err, "Failed to check if Pod is outdated.", "name", pod.Name, "namespace", pod.Namespace)
+			continue
+		}
+		if !outdated {
+			continue
+		}
+
+		controllerUID := pod.UID
+		if owner := metav1.GetControllerOf(pod); owner != nil {
+			controllerUID = owner.UID
+		}
+		workload, ok := workloads[controllerUID]
+		if !ok {
+			workload = getWorkload(ctx, r.Client, pod, pod.Namespace)
+			workloads[controllerUID] = workload
+		}
+		if _, ok := outdatedWorkloads[workload.UID]; !ok {
+			outdatedWorkloads[workload.UID] = &outdatedWorkload{workload: workload, pod: pod}
+		}
+		outdatedWorkloads[workload.UID].pods++
+	}
+
+	outdatedPods.Reset()
+	for _, outdated := range outdatedWorkloads {
+		outdatedPods.WithLabelValues(outdated.workload.Namespace, outdated.workload.Kind, outdated.workload.Name).Set(float64(outdated.pods))
+
+		restarted := false
+		if r.RestartWorkloads {
+			restarted, err = r.restartWorkload(ctx, outdated.workload)
+			if err != nil {
+				log.Error(err, "Failed to restart workload.", "kind", outdated.workload.Kind, "name", outdated.workload.Name, "namespace", outdated.workload.Namespace)
+			}
+		}
+
+		if restarted {
+			r.Injector.Events.Eventf(outdated.pod, outdated.pod.Namespace, corev1.EventTypeNormal, eventReasonOutdatedSidecars, "Restarted workload, because %d Pods use outdated sidecars", outdated.pods)
+		} else {
+			r.Injector.Events.Eventf(outdated.pod, outdated.pod.Namespace, corev1.EventTypeWarning, eventReasonOutdatedSidecars, "%d Pods use outdated sidecars", outdated.pods)
+		}
+	}
+
+	return reconcile.Result{RequeueAfter: r.Interval}, nil
+}
+
+// isOutdated returns true, when the revision of the injected resources of the
+// given Pod differs from the revision of the resources, which would be
+// injected with the given configuration. Pods which were not injected or which
+// were injected before the revision was recorded are never outdated.
+func (r *StaleReconciler) isOutdated(ctx context.Context, pod *corev1.Pod, cfg *Config) (bool, error) {
+	if val, ok := pod.Annotations[annotationStatusKey]; !ok || val != "injected" {
+		return false, nil
+	}
+
+	rec, err := getRecord(pod)
+	if err != nil || rec == nil {
+		return false, err
+	}
+
+	// We remove the status annotation and all labels, containers and volumes
+	// which were added by the sidecar injector from a copy of the Pod, so that
+	// we get the resources, which would be injected into the Pod, when it is
+	// created again. Otherwise an injector could match a label, which was
+	// added by another injector.
+	newPod := pod.DeepCopy()
+	delete(newPod.Annotations, annotationStatusKey)
+	for _, key := range rec.Labels {
+		delete(newPod.Labels, key)
+	}
+	newPod.Spec.InitContainers = slices.DeleteFunc(newPod.Spec.InitContainers, func(c corev1.Container) bool { return rec.hasInitContainer(c.Name) })
+	newPod.Spec.Containers = slices.DeleteFunc(newPod.Spec.Containers, func(c corev1.Container) bool { return rec.hasContainer(c.Name) })
+	newPod.Spec.Volumes = slices.DeleteFunc(newPod.Spec.Volumes, func(v corev1.Volume) bool { return rec.hasVolume(v.Name) })
+
+	res, _, err := r.Injector.getResourcesToInject(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Name: pod.Name, Namespace: pod.Namespace}}, newPod, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	// When nothing is injected anymore, e.g. because the injector of the Pod
+	// was removed, the revision is empty, so that the Pod is outdated.
+	var currentRevision string
+	if res != nil {
+		currentRevision = res.revision
+	}
+
+	return currentRevision != rec.Revision, nil
+}
+
+// restartWorkload triggers a rolling restart of the given workload, by setting
+// an annotation in the Pod template. Only Deployments and StatefulSets are
+// restarted and only when no rollout is in progress, so that we do not restart
+// a workload again, while the outdated Pods are replaced.
+func (r *StaleReconciler) restartWorkload(ctx context.Context, workload *metav1.PartialObjectMetadata) (bool, error) {
+	var obj client.Object
+	key := types.NamespacedName{Namespace: workload.Namespace, Name: workload.Name}
+
+	switch workload.GroupVersionKind() {
+	case appsv1.SchemeGroupVersion.WithKind("Deployment"):
+		deployment := &appsv1.Deployment{}
+		if err := r.Client.Get(ctx, key, deployment); err != nil {
+			return false, err
+		}
+		if deployment.Spec.Paused || deployment.Status.ObservedGeneration < deployment.Generation || deployment.Status.UpdatedReplicas != deployment.Status.Replicas {
+			return false, nil
+		}
+		obj = deployment
+
+	case appsv1.SchemeGroupVersion.WithKind("StatefulSet"):
+		statefulSet := &appsv1.StatefulSet{}
+		if err := r.Client.Get(ctx, key, statefulSet); err != nil {
+			return false, err
+		}
+		if statefulSet.Status.ObservedGeneration < statefulSet.Generation || statefulSet.Status.UpdateRevision != statefulSet.Status.CurrentRevision {
+			return false, nil
+		}
+		obj = statefulSet
+
+	default:
+		return false, nil
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, annotationRestartedAtKey, time.Now().Format(time.RFC3339))
+	if err := r.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+		return false, err
+	}
+
+	log.Info("Restarted workload with outdated sidecars.", "kind", workload.Kind, "name", workload.Name, "namespace", workload.Namespace)
+	return true, nil
+}
+
+// SetupWithManager registers the StaleReconciler in the given manager. The
+// reconciler watches all injected Pods and if the custom resources are enabled
+// all SidecarTemplates and SidecarInjectors and always enqueues the same
+// request for all of them. Pods without the status annotation can never be
+// outdated, so that changes of these Pods are ignored.
+func (r *StaleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	enqueueStaleRequest := handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{staleRequest}
+	})
+
+	controller := ctrl.NewControllerManagedBy(mgr).
+		Named("stale").
+		Watches(&corev1.Pod{}, enqueueStaleRequest, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetAnnotations()[annotationStatusKey] == "injected"
+		})))
+
+	if r.Injector.CustomResources {
+		controller = controller.
+			Watches(&v1alpha1.SidecarTemplate{}, enqueueStaleRequest).
+			Watches(&v1alpha1.SidecarInjector{}, enqueueStaleRequest)
+	}
+
+	return controller.Complete(r)
+}
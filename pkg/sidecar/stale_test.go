@@ -0,0 +1,171 @@
+package sidecar
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Outdated Sidecars", func() {
+	newInjector := func(image string) *Injector {
+		return &Injector{
+			Config: &Config{
+				Injectors: []InjectorData{
+					{
+						Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "stale"}},
+						Containers: []string{"stale-container"},
+					},
+				},
+				Containers: []Container{
+					{Container: corev1.Container{Name: "stale-container", Image: image}},
+				},
+			},
+			Decoder: admission.NewDecoder(scheme.Scheme),
+		}
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "default", UID: "deployment-uid", Generation: 1},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "stale-12345",
+			Namespace: "default",
+			UID:       "replicaset-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "Deployment", Name: "stale", UID: "deployment-uid", Controller: ptr.To(true)},
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "stale-12345-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "stale"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "stale-12345", UID: "replicaset-uid", Controller: ptr.To(true)},
+			},
+		},
+	}
+
+	Context("Computing the revision", func() {
+		It("Should only change when the injected definitions change", func() {
+			injectedPod, res := handle(newInjector("stale-image:1"), admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			injectedRecord, err := getRecord(injectedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(injectedRecord.Revision).NotTo(BeEmpty())
+
+			samePod, _ := handle(newInjector("stale-image:1"), admissionv1.Create, pod)
+			sameRecord, err := getRecord(samePod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sameRecord.Revision).To(Equal(injectedRecord.Revision))
+
+			changedPod, _ := handle(newInjector("stale-image:2"), admissionv1.Create, pod)
+			changedRecord, err := getRecord(changedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changedRecord.Revision).NotTo(Equal(injectedRecord.Revision))
+		})
+
+		It("Should not report Pods with required volumes as outdated after the injection", func() {
+			injector := newInjector("stale-image:1")
+			injector.Config.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "stale-volume", MountPath: "/data"}}
+			injector.Config.Volumes = []Volume{{Volume: corev1.Volume{Name: "stale-volume", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}}
+
+			injectedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(injectedPod.Spec.Volumes).To(HaveLen(1))
+
+			outdated, err := (&StaleReconciler{Injector: injector}).isOutdated(context.Background(), injectedPod, injector.Config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outdated).To(BeFalse())
+		})
+
+		It("Should not match labels, which were added by a Pod mutation", func() {
+			injector := newInjector("stale-image:1")
+			injector.Config.Injectors[0].Pod = &PodMutation{Labels: map[string]string{"mesh": "enabled"}}
+			injector.Config.Injectors = append(injector.Config.Injectors, InjectorData{
+				Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"mesh": "enabled"}},
+				Containers: []string{"mesh-container"},
+			})
+			injector.Config.Containers = append(injector.Config.Containers, Container{Container: corev1.Container{Name: "mesh-container", Image: "mesh-image"}})
+
+			injectedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(injectedPod.Labels).To(HaveKeyWithValue("mesh", "enabled"))
+			Expect(injectedPod.Spec.Containers).To(HaveLen(1))
+
+			injectedRecord, err := getRecord(injectedPod)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(injectedRecord.Labels).To(Equal([]string{"mesh"}))
+
+			outdated, err := (&StaleReconciler{Injector: injector}).isOutdated(context.Background(), injectedPod, injector.Config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outdated).To(BeFalse())
+		})
+
+		It("Should report Pods as outdated when their injector was removed", func() {
+			injector := newInjector("stale-image:1")
+
+			injectedPod, res := handle(injector, admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+
+			injector.Config.Injectors = nil
+			outdated, err := (&StaleReconciler{Injector: injector}).isOutdated(context.Background(), injectedPod, injector.Config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outdated).To(BeTrue())
+		})
+
+		It("Should be empty when nothing is injected", func() {
+			Expect(revision(&Config{}, nil)).To(BeEmpty())
+		})
+	})
+
+	Context("Detecting outdated Pods", func() {
+		It("Should report and restart workloads with outdated Pods", func() {
+			injectedPod, res := handle(newInjector("stale-image:1"), admissionv1.Create, pod)
+			Expect(res.Allowed).To(BeTrue())
+
+			c := fake.NewClientBuilder().WithObjects(deployment.DeepCopy(), replicaSet, injectedPod).WithStatusSubresource(&appsv1.Deployment{}).Build()
+
+			By("Not reporting Pods injected with the current configuration")
+			injector := newInjector("stale-image:1")
+			injector.Client = c
+			reconciler := &StaleReconciler{Client: c, Injector: injector}
+			_, err := reconciler.Reconcile(ctx, staleRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutil.CollectAndCount(outdatedPods)).To(Equal(0))
+
+			By("Reporting Pods injected with an outdated configuration")
+			injector = newInjector("stale-image:2")
+			injector.Client = c
+			reconciler = &StaleReconciler{Client: c, Injector: injector}
+			_, err = reconciler.Reconcile(ctx, staleRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(testutil.ToFloat64(outdatedPods.WithLabelValues("default", "Deployment", "stale"))).To(Equal(float64(1)))
+
+			updatedDeployment := &appsv1.Deployment{}
+			Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "stale"}, updatedDeployment)).To(Succeed())
+			Expect(updatedDeployment.Spec.Template.Annotations).NotTo(HaveKey(annotationRestartedAtKey))
+
+			By("Restarting workloads with outdated Pods")
+			reconciler.RestartWorkloads = true
+			_, err = reconciler.Reconcile(ctx, staleRequest)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "stale"}, updatedDeployment)).To(Succeed())
+			Expect(updatedDeployment.Spec.Template.Annotations).To(HaveKey(annotationRestartedAtKey))
+		})
+	})
+})
@@ -5,9 +5,12 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/ricoberger/sidecar-injector/pkg/api/v1alpha1"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
@@ -50,6 +53,8 @@ var _ = BeforeSuite(func() {
 	sideEffects := admissionv1.SideEffectClassNone
 
 	testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "charts", "sidecar-injector", "crds")},
+		ErrorIfCRDPathMissing: true,
 		WebhookInstallOptions: envtest.WebhookInstallOptions{
 			MutatingWebhooks: []*admissionv1.MutatingWebhookConfiguration{
 				{
@@ -104,6 +109,9 @@ var _ = BeforeSuite(func() {
 	err = admissionv1.AddToScheme(scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	err = v1alpha1.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
@@ -121,76 +129,106 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	mgr.GetWebhookServer().Register("/mutate", &webhook.Admission{
-		Handler: &Injector{
-			Client: mgr.GetClient(),
-			Config: &Config{
-				Injectors: []InjectorData{
-					{
-						Selector: metav1.LabelSelector{
-							MatchLabels: map[string]string{
-								"sidecar-injector": "injector-test",
-							},
-						},
-						Containers:     []string{"test-container"},
-						InitContainers: []string{"test-initcontainer"},
-						Volumes:        []string{"test-volume"},
+	injectorConfig := &Config{
+		Injectors: []InjectorData{
+			{
+				Selector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"sidecar-injector": "injector-test",
 					},
 				},
-				Containers: []corev1.Container{
-					{
-						Name:            "test-container",
-						Image:           "test-image",
-						ImagePullPolicy: corev1.PullIfNotPresent,
-						Resources: corev1.ResourceRequirements{
-							Requests: corev1.ResourceList{
-								"cpu":    resource.MustParse("100m"),
-								"memory": resource.MustParse("100Mi"),
-							},
-							Limits: corev1.ResourceList{
-								"cpu":    resource.MustParse("200m"),
-								"memory": resource.MustParse("200Mi"),
-							},
-						},
+				Containers:     []string{"test-container"},
+				InitContainers: []string{"test-initcontainer"},
+				Volumes:        []string{"test-volume"},
+			},
+			{
+				Name: "namespace-injector",
+				Selector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"sidecar-injector": "namespace-injector-test",
 					},
-					{
-						Name:            "test-initcontainer",
-						Image:           "test-initimage",
-						ImagePullPolicy: corev1.PullIfNotPresent,
-						Resources: corev1.ResourceRequirements{
-							Requests: corev1.ResourceList{
-								"cpu":    resource.MustParse("50m"),
-								"memory": resource.MustParse("50Mi"),
-							},
-							Limits: corev1.ResourceList{
-								"cpu":    resource.MustParse("50m"),
-								"memory": resource.MustParse("50Mi"),
-							},
+				},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"team": "payments",
+					},
+				},
+				Containers: []string{"test-container"},
+			},
+		},
+		Containers: []Container{
+			{
+				Container: corev1.Container{
+					Name:            "test-container",
+					Image:           "test-image",
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							"cpu":    resource.MustParse("100m"),
+							"memory": resource.MustParse("100Mi"),
+						},
+						Limits: corev1.ResourceList{
+							"cpu":    resource.MustParse("200m"),
+							"memory": resource.MustParse("200Mi"),
 						},
 					},
 				},
-				Volumes: []corev1.Volume{
-					{
-						Name: "test-volume",
-						VolumeSource: corev1.VolumeSource{
-							Secret: &corev1.SecretVolumeSource{
-								SecretName: "secret-config",
-							},
+			},
+			{
+				Container: corev1.Container{
+					Name:            "test-initcontainer",
+					Image:           "test-initimage",
+					ImagePullPolicy: corev1.PullIfNotPresent,
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							"cpu":    resource.MustParse("50m"),
+							"memory": resource.MustParse("50Mi"),
+						},
+						Limits: corev1.ResourceList{
+							"cpu":    resource.MustParse("50m"),
+							"memory": resource.MustParse("50Mi"),
 						},
 					},
 				},
-				EnvironmentVariables: []EnvironmentVariable{
-					{
-						Name:       "test-env-var",
-						Container:  "test-container",
-						Annotation: "sidecar-injector.ricoberger.de/test-env-var",
+			},
+		},
+		Volumes: []Volume{
+			{
+				Volume: corev1.Volume{
+					Name: "test-volume",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName: "secret-config",
+						},
 					},
 				},
 			},
+		},
+		EnvironmentVariables: []EnvironmentVariable{
+			{
+				Name:       "test-env-var",
+				Container:  "test-container",
+				Annotation: "sidecar-injector.ricoberger.de/test-env-var",
+			},
+		},
+	}
+
+	mgr.GetWebhookServer().Register("/mutate", &webhook.Admission{
+		Handler: &Injector{
+			Client:  mgr.GetClient(),
+			Config:  injectorConfig,
 			Decoder: admission.NewDecoder(mgr.GetScheme()),
+
+			CustomResources: true,
 		},
 	})
 
+	err = (&StatusReconciler{
+		Client: mgr.GetClient(),
+		Config: injectorConfig,
+	}).SetupWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
 	go func() {
 		defer GinkgoRecover()
 		err = mgr.Start(ctx)
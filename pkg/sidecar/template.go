@@ -0,0 +1,225 @@
+package sidecar
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// templateData is the data which is available in the templates of the
+// container and volume definitions. The data is created once per admission
+// request from the Pod, where the resources should be injected into.
+type templateData struct {
+	Name               string
+	GenerateName       string
+	Namespace          string
+	Labels             map[string]string
+	Annotations        map[string]string
+	ServiceAccountName string
+	Containers         []corev1.Container
+}
+
+// templateFuncs are the additional functions, which can be used in the
+// templates of the container and volume definitions.
+var templateFuncs = template.FuncMap{
+	"default": func(defaultValue, value string) string {
+		if value == "" {
+			return defaultValue
+		}
+		return value
+	},
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    strings.ReplaceAll,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+}
+
+// newTemplateData returns the template data for the given Pod. The Namespace
+// is passed separately, because it is not always set in the Pod during the
+// admission request.
+func newTemplateData(pod *corev1.Pod, namespace string) *templateData {
+	containers := make([]corev1.Container, len(pod.Spec.Containers))
+	for index := range pod.Spec.Containers {
+		pod.Spec.Containers[index].DeepCopyInto(&containers[index])
+	}
+
+	return &templateData{
+		Name:               pod.Name,
+		GenerateName:       pod.GenerateName,
+		Namespace:          namespace,
+		Labels:             pod.Labels,
+		Annotations:        pod.Annotations,
+		ServiceAccountName: pod.Spec.ServiceAccountName,
+		Containers:         containers,
+	}
+}
+
+// templateCache contains the parsed templates of a configuration, so that the
+// templates are only parsed once, when the configuration is compiled. The
+// templates are stored by the path of the field and the template, so that the
+// same template in different fields gets the name of its own field.
+type templateCache map[string]*template.Template
+
+func templateKey(value string, fldPath *field.Path) string {
+	return fldPath.String() + "\x00" + value
+}
+
+// add parses all templates in the string fields of the given object and adds
+// them to the cache. It returns an error for each template, which can not be
+// parsed.
+func (c templateCache) add(obj any, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	// The walk can not fail, because the errors are collected, so that all
+	// invalid templates are reported.
+	_ = walkTemplates(reflect.ValueOf(obj), fldPath, func(value string, fldPath *field.Path) (string, error) {
+		allErrs = append(allErrs, c.addTemplate(value, fldPath)...)
+		return value, nil
+	})
+
+	return allErrs
+}
+
+// addTemplate parses the given template and adds it to the cache.
+func (c templateCache) addTemplate(value string, fldPath *field.Path) field.ErrorList {
+	tmpl, err := parseTemplate(value, fldPath)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, value, err.Error())}
+	}
+
+	c[templateKey(value, fldPath)] = tmpl
+	return nil
+}
+
+// get returns the parsed template for the given field. If the template is not
+// cached, e.g. because the configuration was not compiled, it is parsed.
+func (c templateCache) get(value string, fldPath *field.Path) (*template.Template, error) {
+	if tmpl, ok := c[templateKey(value, fldPath)]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := parseTemplate(value, fldPath)
+	if err != nil {
+		return nil, field.Invalid(fldPath, value, err.Error())
+	}
+	return tmpl, nil
+}
+
+// renderTemplates renders all string fields of the given object, which
+// contain a template. The object must be a pointer, so that the rendered
+// values can be set. If a template can not be rendered, the returned error
+// contains the path of the field with the invalid template.
+func renderTemplates(obj any, data *templateData, fldPath *field.Path, cache templateCache) error {
+	return walkTemplates(reflect.ValueOf(obj), fldPath, func(value string, fldPath *field.Path) (string, error) {
+		return renderTemplate(value, data, fldPath, cache)
+	})
+}
+
+// walkTemplates calls the given function for all string fields of the given
+// value, which contain a template action, and sets the fields to the returned
+// values. Strings without a template action are skipped, so that we do not
+// have to parse them.
+func walkTemplates(value reflect.Value, fldPath *field.Path, fn func(string, *field.Path) (string, error)) error {
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			return nil
+		}
+		return walkTemplates(value.Elem(), fldPath, fn)
+
+	case reflect.Struct:
+		valueType := value.Type()
+		for index := 0; index < value.NumField(); index++ {
+			structField := valueType.Field(index)
+			if !structField.IsExported() {
+				continue
+			}
+
+			// Embedded structs are inlined, so that we do not add their name
+			// to the path of the field. For all other fields we use the name
+			// from the json tag, so that the path matches the configuration.
+			childPath := fldPath
+			if !structField.Anonymous {
+				name := strings.Split(structField.Tag.Get("json"), ",")[0]
+				if name == "" {
+					name = structField.Name
+				}
+				childPath = fldPath.Child(name)
+			}
+
+			if err := walkTemplates(value.Field(index), childPath, fn); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		for index := 0; index < value.Len(); index++ {
+			if err := walkTemplates(value.Index(index), fldPath.Index(index), fn); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String || value.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+
+		iter := value.MapRange()
+		for iter.Next() {
+			if !strings.Contains(iter.Value().String(), "{{") {
+				continue
+			}
+
+			rendered, err := fn(iter.Value().String(), fldPath.Key(iter.Key().String()))
+			if err != nil {
+				return err
+			}
+			if rendered != iter.Value().String() {
+				value.SetMapIndex(iter.Key(), reflect.ValueOf(rendered).Convert(value.Type().Elem()))
+			}
+		}
+
+	case reflect.String:
+		if !strings.Contains(value.String(), "{{") {
+			return nil
+		}
+
+		rendered, err := fn(value.String(), fldPath)
+		if err != nil {
+			return err
+		}
+		if rendered != value.String() && value.CanSet() {
+			value.SetString(rendered)
+		}
+	}
+
+	return nil
+}
+
+// parseTemplate parses the given value as template with the additional
+// functions. Missing keys of maps, e.g. a label which is not set, result in an
+// empty string, so that they can be handled via the "default" function.
+func parseTemplate(value string, fldPath *field.Path) (*template.Template, error) {
+	return template.New(fldPath.String()).Option("missingkey=zero").Funcs(templateFuncs).Parse(value)
+}
+
+// renderTemplate renders the given value as template with the given data. The
+// parsed template is taken from the given cache.
+func renderTemplate(value string, data any, fldPath *field.Path, cache templateCache) (string, error) {
+	tmpl, err := cache.get(value, fldPath)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", field.Invalid(fldPath, value, err.Error())
+	}
+
+	return buf.String(), nil
+}
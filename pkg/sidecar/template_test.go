@@ -0,0 +1,153 @@
+package sidecar
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ = Describe("Template", func() {
+	Context("Rendering templates", func() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "my-pod",
+				Labels:      map[string]string{"app": "my-app"},
+				Annotations: map[string]string{"team": "payments"},
+			},
+			Spec: corev1.PodSpec{
+				ServiceAccountName: "my-serviceaccount",
+				Containers: []corev1.Container{
+					{Name: "my-container", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+				},
+			},
+		}
+
+		It("Should render container and volume definitions", func() {
+			data := newTemplateData(pod, "default")
+
+			container := corev1.Container{
+				Name:  "log-shipper",
+				Image: "log-shipper:latest",
+				Args:  []string{"--tag={{ .Labels.app }}", "--upstream=http://localhost:{{ (index (index .Containers 0).Ports 0).ContainerPort }}"},
+				Env: []corev1.EnvVar{
+					{Name: "NAMESPACE", Value: "{{ .Namespace }}"},
+					{Name: "TEAM", Value: `{{ index .Annotations "team" | upper }}`},
+					{Name: "OWNER", Value: `{{ index .Labels "owner" | default "unknown" }}`},
+					{Name: "VERSION", Value: `{{ default "latest" .Labels.version }}`},
+					{Name: "SERVICE_ACCOUNT", Value: "{{ .ServiceAccountName }}"},
+				},
+			}
+			Expect(renderTemplates(&container, data, field.NewPath("containers").Key("log-shipper"), nil)).To(Succeed())
+			Expect(container.Args).To(Equal([]string{"--tag=my-app", "--upstream=http://localhost:8080"}))
+			Expect(container.Env[0].Value).To(Equal("default"))
+			Expect(container.Env[1].Value).To(Equal("PAYMENTS"))
+			Expect(container.Env[2].Value).To(Equal("unknown"))
+			Expect(container.Env[3].Value).To(Equal("latest"))
+			Expect(container.Env[4].Value).To(Equal("my-serviceaccount"))
+
+			volume := corev1.Volume{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "{{ .Labels.app }}-config"},
+					},
+				},
+			}
+			Expect(renderTemplates(&volume, data, field.NewPath("volumes").Key("config"), nil)).To(Succeed())
+			Expect(volume.ConfigMap.Name).To(Equal("my-app-config"))
+		})
+
+		It("Should return error with the path of the invalid field", func() {
+			container := corev1.Container{
+				Name: "log-shipper",
+				Env:  []corev1.EnvVar{{Name: "UPSTREAM", Value: "{{ (index .Containers 1).Name }}"}},
+			}
+
+			err := renderTemplates(&container, newTemplateData(pod, "default"), field.NewPath("containers").Key("log-shipper"), nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix(`containers[log-shipper].env[0].value: Invalid value: "{{ (index .Containers 1).Name }}"`))
+		})
+
+		It("Should not render definitions without enabled templates", func() {
+			injector := &Injector{
+				Config: &Config{
+					Containers: []Container{
+						{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper:latest", Args: []string{"--format={{ .Message }}"}}},
+					},
+				},
+				Decoder: admission.NewDecoder(scheme.Scheme),
+			}
+
+			pod, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						annotationInjectKey:     "enabled",
+						annotationContainersKey: "log-shipper",
+					},
+				},
+			})
+			Expect(res.Allowed).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Args).To(Equal([]string{"--format={{ .Message }}"}))
+		})
+
+		It("Should report invalid templates when the configuration is loaded", func() {
+			cfg := &Config{
+				Containers: []Container{
+					{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper:{{ .Labels.version"}, Templates: true},
+					{Container: corev1.Container{Name: "agent", Image: "agent:{{ .Labels.version"}},
+				},
+			}
+
+			allErrs := cfg.validate()
+			Expect(allErrs).To(HaveLen(1))
+			Expect(allErrs[0].Field).To(Equal("containers[0].image"))
+			Expect(allErrs[0].Type).To(Equal(field.ErrorTypeInvalid))
+		})
+
+		It("Should deny Pods when a template can not be rendered", func() {
+			injector := &Injector{
+				Config: &Config{
+					Containers: []Container{
+						{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper:{{ (index .Containers 1).Name }}"}, Templates: true},
+					},
+				},
+				Decoder: admission.NewDecoder(scheme.Scheme),
+			}
+
+			_, res := handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						annotationInjectKey:     "enabled",
+						annotationContainersKey: "log-shipper",
+					},
+				},
+			})
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(ContainSubstring("containers[log-shipper].image"))
+
+			_, res = handle(injector, admissionv1.Create, &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-pod",
+					Namespace: "default",
+					Annotations: map[string]string{
+						annotationInjectKey:     "enabled",
+						annotationContainersKey: "log-shipper",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app"}, {Name: "v1.0.0"}},
+				},
+			})
+			Expect(res.Allowed).To(BeTrue())
+		})
+	})
+})
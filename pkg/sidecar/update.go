@@ -0,0 +1,143 @@
+package sidecar
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// handleUpdate handles an update of a Pod. Since containers can not be added
+// to an existing Pod, we never inject any resources on updates. Instead we
+// verify that the injected containers and volumes of the Pod were not removed
+// or changed. If the annotations should be protected, we also deny updates
+// which remove or change the annotations of the sidecar injector.
+func (i *Injector) handleUpdate(req admission.Request, pod *corev1.Pod, cfg *Config) (admission.Response, string, string) {
+	oldPod := &corev1.Pod{}
+	if err := i.Decoder.DecodeRaw(req.OldObject, oldPod); err != nil {
+		log.Error(err, "Could not decode old object.", "name", req.Name, "namespace", req.Namespace)
+		return admission.Errored(http.StatusBadRequest, err), outcomeErrored, "decode"
+	}
+
+	// If the Pod was not injected on creation, there is nothing to verify and
+	// we can not inject anything into the existing Pod.
+	if val, ok := oldPod.Annotations[annotationStatusKey]; !ok || val != "injected" {
+		return admission.Allowed("No injection required."), outcomeSkipped, reasonUpdate
+	}
+
+	if err := verifyInjectedResources(oldPod, pod, cfg); err != nil {
+		log.Info("Injected resources were changed.", "name", req.Name, "namespace", req.Namespace, "error", err.Error())
+		return admission.Denied(err.Error()), outcomeDenied, "injected-resources-changed"
+	}
+
+	if cfg.ProtectAnnotations {
+		if err := verifyAnnotations(oldPod, pod); err != nil {
+			log.Info("Annotations were changed.", "name", req.Name, "namespace", req.Namespace, "error", err.Error())
+			return admission.Denied(err.Error()), outcomeDenied, "annotations-changed"
+		}
+	}
+
+	return admission.Allowed("Injected resources are unchanged."), outcomeSkipped, reasonUpdate
+}
+
+// verifyInjectedResources checks that all injected init containers,
+// containers and volumes of the old Pod are still present and unchanged in the
+// new Pod. The injected resources are read from the record of the old Pod. If
+// the old Pod doesn't have a record, a container or volume is treated as
+// injected, when a container or volume with the same name is defined in the
+// configuration.
+func verifyInjectedResources(oldPod, pod *corev1.Pod, cfg *Config) error {
+	rec, err := getRecord(oldPod)
+	if err != nil {
+		return fmt.Errorf("invalid annotation %q: %w", annotationRecordKey, err)
+	}
+
+	inConfig := func(name string) bool {
+		return slices.ContainsFunc(cfg.Containers, func(c Container) bool { return c.Name == name })
+	}
+	isInitContainer, isContainer := inConfig, inConfig
+	isVolume := func(name string) bool {
+		return slices.ContainsFunc(cfg.Volumes, func(v Volume) bool { return v.Name == name })
+	}
+	if rec != nil {
+		isInitContainer, isContainer, isVolume = rec.hasInitContainer, rec.hasContainer, rec.hasVolume
+	}
+
+	for _, oldContainer := range oldPod.Spec.InitContainers {
+		if !isInitContainer(oldContainer.Name) {
+			continue
+		}
+		if err := verifyContainer(oldContainer, pod.Spec.InitContainers, "init container"); err != nil {
+			return err
+		}
+	}
+
+	for _, oldContainer := range oldPod.Spec.Containers {
+		if !isContainer(oldContainer.Name) {
+			continue
+		}
+		if err := verifyContainer(oldContainer, pod.Spec.Containers, "container"); err != nil {
+			return err
+		}
+	}
+
+	for _, oldVolume := range oldPod.Spec.Volumes {
+		if !isVolume(oldVolume.Name) {
+			continue
+		}
+
+		index := slices.IndexFunc(pod.Spec.Volumes, func(v corev1.Volume) bool { return v.Name == oldVolume.Name })
+		if index < 0 {
+			return fmt.Errorf("injected volume %q must not be removed", oldVolume.Name)
+		}
+		if !equality.Semantic.DeepEqual(oldVolume, pod.Spec.Volumes[index]) {
+			return fmt.Errorf("injected volume %q must not be changed", oldVolume.Name)
+		}
+	}
+
+	return nil
+}
+
+func verifyContainer(oldContainer corev1.Container, containers []corev1.Container, kind string) error {
+	index := slices.IndexFunc(containers, func(c corev1.Container) bool { return c.Name == oldContainer.Name })
+	if index < 0 {
+		return fmt.Errorf("injected %s %q must not be removed", kind, oldContainer.Name)
+	}
+	if !equality.Semantic.DeepEqual(oldContainer, containers[index]) {
+		return fmt.Errorf("injected %s %q must not be changed", kind, oldContainer.Name)
+	}
+
+	return nil
+}
+
+// verifyAnnotations checks that all annotations of the sidecar injector from
+// the old Pod are still present and unchanged in the new Pod.
+func verifyAnnotations(oldPod, pod *corev1.Pod) error {
+	for _, key := range sortedKeys(oldPod.Annotations) {
+		if key != annotationInjectKey && !strings.HasPrefix(key, annotationInjectKey+"/") {
+			continue
+		}
+
+		if val, ok := pod.Annotations[key]; !ok || val != oldPod.Annotations[key] {
+			return fmt.Errorf("annotation %q must not be removed or changed", key)
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of the given map in a sorted order, so that
+// errors are always reported for the same key.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
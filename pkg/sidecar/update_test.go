@@ -0,0 +1,146 @@
+package sidecar
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// update creates an admission request for the update of the given old Pod to
+// the given Pod and calls the Handle function of the injector.
+func update(injector *Injector, oldPod, pod *corev1.Pod) admission.Response {
+	oldRaw, err := json.Marshal(oldPod)
+	Expect(err).NotTo(HaveOccurred())
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+
+	return injector.Handle(ctx, admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: runtime.RawExtension{Raw: oldRaw},
+		},
+	})
+}
+
+var _ = Describe("Update", func() {
+	Context("Updating Pods", func() {
+		cfg := &Config{
+			Injectors: []InjectorData{
+				{
+					Selector:   metav1.LabelSelector{MatchLabels: map[string]string{"app": "update"}},
+					Containers: []string{"log-shipper"},
+					Volumes:    []string{"logs"},
+				},
+			},
+			Containers: []Container{
+				{Container: corev1.Container{Name: "log-shipper", Image: "log-shipper:v1"}},
+			},
+			Volumes: []Volume{
+				{Volume: corev1.Volume{Name: "logs", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+			},
+		}
+		injector := &Injector{Config: cfg, Decoder: admission.NewDecoder(scheme.Scheme)}
+
+		newPod := func() *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "update", Namespace: "default", Labels: map[string]string{"app": "update"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Image: "app"}},
+				},
+			}
+		}
+
+		It("Should not inject resources into Pods which were not injected on creation", func() {
+			pod := newPod()
+			pod.Labels["version"] = "v2"
+
+			res := update(injector, newPod(), pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Patches).To(BeEmpty())
+		})
+
+		It("Should allow updates which do not change injected resources", func() {
+			oldPod, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+
+			pod := oldPod.DeepCopy()
+			pod.Labels["version"] = "v2"
+			pod.Spec.Containers[0].Image = "app:v2"
+
+			res = update(injector, oldPod, pod)
+			Expect(res.Allowed).To(BeTrue())
+			Expect(res.Patches).To(BeEmpty())
+		})
+
+		It("Should deny updates which change or remove injected resources", func() {
+			oldPod, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+
+			pod := oldPod.DeepCopy()
+			pod.Spec.Containers[1].Image = "log-shipper:v2"
+
+			res = update(injector, oldPod, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`injected container "log-shipper" must not be changed`))
+
+			pod = oldPod.DeepCopy()
+			pod.Spec.Volumes = nil
+
+			res = update(injector, oldPod, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`injected volume "logs" must not be removed`))
+		})
+
+		It("Should verify the resources from the record of the Pod", func() {
+			oldPod, res := handle(injector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+
+			pod := oldPod.DeepCopy()
+			pod.Spec.Containers[1].Image = "log-shipper:v2"
+
+			// The container was removed from the configuration after the Pod
+			// was injected, but it is still contained in the record.
+			res = update(&Injector{Config: &Config{}, Decoder: injector.Decoder}, oldPod, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`injected container "log-shipper" must not be changed`))
+
+			// The application container is not contained in the record, even if
+			// a container with the same name is defined in the configuration.
+			appInjector := &Injector{Config: &Config{Containers: []Container{{Container: corev1.Container{Name: "app"}}}}, Decoder: injector.Decoder}
+			pod = oldPod.DeepCopy()
+			pod.Spec.Containers[0].Image = "app:v2"
+
+			res = update(appInjector, oldPod, pod)
+			Expect(res.Allowed).To(BeTrue())
+		})
+
+		It("Should deny updates which remove annotations when they are protected", func() {
+			protectedInjector := &Injector{Config: &Config{}, Decoder: injector.Decoder}
+			*protectedInjector.Config = *cfg
+			protectedInjector.Config.ProtectAnnotations = true
+
+			oldPod, res := handle(protectedInjector, admissionv1.Create, newPod())
+			Expect(res.Allowed).To(BeTrue())
+
+			pod := oldPod.DeepCopy()
+			delete(pod.Annotations, annotationStatusKey)
+
+			res = update(injector, oldPod, pod)
+			Expect(res.Allowed).To(BeTrue())
+
+			res = update(protectedInjector, oldPod, pod)
+			Expect(res.Allowed).To(BeFalse())
+			Expect(res.Result.Message).To(Equal(`annotation "sidecar-injector.ricoberger.de/status" must not be removed or changed`))
+		})
+	})
+})